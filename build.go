@@ -0,0 +1,513 @@
+package main
+
+// the namespaces reachable by imports from the namespace being compiled are loaded (from the build cache
+// or by lexing, reading and parsing their files) and then compiled, in both cases by a pool of workers:
+// the files of every namespace are parsed concurrently, and a namespace is compiled as soon as its imports are,
+// so independent namespaces are compiled concurrently; a namespace's output depends only on its own source
+// and its imports, so it is the same however the work is scheduled
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"unicode/utf8"
+)
+
+type importState int
+
+const (
+	importUnvisited importState = iota
+	importInProgress
+	importDone
+)
+
+type importGraph struct {
+	TopDefs      map[NSNameFull]*TopDefs // the summary from the cache where the source is unchanged
+	States       map[NSNameFull]importState
+	Order        []NSNameFull               // every imported namespace comes before the namespaces which import it
+	Cached       map[NSNameFull]*cacheEntry // the entries whose source is unchanged
+	SourceHashes map[NSNameFull]string
+	LastOutputs  map[NSNameFull][]string // the files written by the last compilation, from the cache (even if stale)
+	LoadErrors   map[NSNameFull]error    // reported when the namespace is reached in order
+	Options      buildOptions
+}
+
+// the namespaces already compiled are done
+func newImportGraph(namespaces map[NSNameFull]*Namespace, options buildOptions) *importGraph {
+	g := &importGraph{
+		TopDefs:      map[NSNameFull]*TopDefs{},
+		States:       map[NSNameFull]importState{},
+		Cached:       map[NSNameFull]*cacheEntry{},
+		SourceHashes: map[NSNameFull]string{},
+		LastOutputs:  map[NSNameFull][]string{},
+		LoadErrors:   map[NSNameFull]error{},
+		Options:      options,
+	}
+	for name := range namespaces {
+		g.States[name] = importDone
+	}
+	return g
+}
+
+// a source file to lex, read and parse
+type fileJob struct {
+	Namespace NSNameFull
+	Index     int // position among the files of the namespace
+	File      string
+}
+
+type fileResult struct {
+	Job     fileJob
+	TopDefs *TopDefs
+	Err     error
+}
+
+// loads the namespace and every namespace it imports directly or indirectly;
+// an error loading a namespace is recorded in LoadErrors rather than stopping the others
+func (g *importGraph) load(root NSNameFull, nsFileLookup map[NSNameFull][]string) {
+	jobs := make(chan fileJob)
+	results := make(chan fileResult)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for job := range jobs {
+				topDefs, err := parseFile(job.File, job.Index == 0)
+				results <- fileResult{job, topDefs, err}
+			}
+		}()
+	}
+	defer close(jobs)
+
+	var queue []fileJob
+	seen := map[NSNameFull]bool{}
+	fileDefs := map[NSNameFull][]*TopDefs{} // parsed files of each namespace, by index
+	fileErrs := map[NSNameFull][]error{}
+	pending := map[NSNameFull]int{} // number of files not yet parsed
+	var discover func(namespace NSNameFull)
+	discover = func(namespace NSNameFull) {
+		if seen[namespace] || g.States[namespace] == importDone {
+			return
+		}
+		seen[namespace] = true
+		files := nsFileLookup[namespace]
+		if len(files) == 0 {
+			g.LoadErrors[namespace] = errors.New("No source files found for namespace: " + string(namespace))
+			return
+		}
+		sourceHash, err := hashSources(files)
+		if err != nil {
+			g.LoadErrors[namespace] = err
+			return
+		}
+		g.SourceHashes[namespace] = sourceHash
+		entry := readCacheEntry(namespace)
+		if entry != nil {
+			g.LastOutputs[namespace] = entry.Outputs
+		}
+		if entry != nil && entry.SourceHash == sourceHash {
+			g.Cached[namespace] = entry
+			g.TopDefs[namespace] = entry.Summary
+			for _, importDef := range entry.Summary.Imports {
+				discover(importDef.Namespace)
+			}
+			return
+		}
+		fileDefs[namespace] = make([]*TopDefs, len(files))
+		fileErrs[namespace] = make([]error, len(files))
+		pending[namespace] = len(files)
+		for i, file := range files {
+			queue = append(queue, fileJob{namespace, i, file})
+		}
+	}
+	discover(root)
+
+	outstanding := 0
+	for len(queue) > 0 || outstanding > 0 {
+		var send chan fileJob // nil (so never ready) when there is nothing to send
+		var next fileJob
+		if len(queue) > 0 {
+			send = jobs
+			next = queue[0]
+		}
+		select {
+		case send <- next:
+			queue = queue[1:]
+			outstanding++
+		case result := <-results:
+			outstanding--
+			namespace := result.Job.Namespace
+			fileDefs[namespace][result.Job.Index] = result.TopDefs
+			fileErrs[namespace][result.Job.Index] = result.Err
+			pending[namespace]--
+			if pending[namespace] > 0 {
+				break
+			}
+			topDefs, err := mergeTopDefs(fileDefs[namespace], fileErrs[namespace])
+			if err != nil {
+				g.LoadErrors[namespace] = err
+				break
+			}
+			g.TopDefs[namespace] = topDefs
+			for _, importDef := range topDefs.Imports {
+				discover(importDef.Namespace)
+			}
+		}
+	}
+}
+
+// the definitions of the files in order (or the error of the first file which failed)
+func mergeTopDefs(files []*TopDefs, errs []error) (*TopDefs, error) {
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	topDefs := &TopDefs{
+		Classes: []ClassDef{},
+		Structs: []StructDef{},
+		Funcs:   []FuncDef{},
+		Globals: []GlobalDef{},
+		Imports: []ImportDef{},
+	}
+	for _, file := range files {
+		topDefs.Classes = append(topDefs.Classes, file.Classes...)
+		topDefs.Structs = append(topDefs.Structs, file.Structs...)
+		topDefs.Interfaces = append(topDefs.Interfaces, file.Interfaces...)
+		topDefs.Funcs = append(topDefs.Funcs, file.Funcs...)
+		topDefs.Globals = append(topDefs.Globals, file.Globals...)
+		topDefs.Imports = append(topDefs.Imports, file.Imports...)
+	}
+	return topDefs, nil
+}
+
+// appends the loaded namespaces to Order, imports first
+// (chain is the path of imports which reached the namespace, reported if the namespace imports itself through it)
+func (g *importGraph) order(namespace NSNameFull, chain []NSNameFull) error {
+	switch g.States[namespace] {
+	case importDone:
+		// already reached through another path, e.g. a diamond of imports
+		return nil
+	case importInProgress:
+		path := ""
+		for _, name := range chain {
+			path += string(name) + " imports "
+		}
+		return errors.New("Recursive import dependency: " + path + string(namespace))
+	}
+	if err := g.LoadErrors[namespace]; err != nil {
+		return err
+	}
+	g.States[namespace] = importInProgress
+	chain = append(chain, namespace)
+	for _, importDef := range g.TopDefs[namespace].Imports {
+		err := g.order(importDef.Namespace, chain)
+		if err != nil {
+			return err
+		}
+	}
+	g.States[namespace] = importDone
+	g.Order = append(g.Order, namespace)
+	return nil
+}
+
+type compileResult struct {
+	Name      NSNameFull
+	Namespace *Namespace
+	Err       error
+}
+
+// compiles the namespaces of Order, each once its imports are compiled;
+// if any fail, the error reported is that of the first in Order
+func (g *importGraph) compile(nsFileLookup map[NSNameFull][]string, namespaces map[NSNameFull]*Namespace) error {
+	waiting := map[NSNameFull]int{} // number of imports not yet compiled
+	dependents := map[NSNameFull][]NSNameFull{}
+	var ready []NSNameFull
+	for _, name := range g.Order {
+		imported := map[NSNameFull]bool{}
+		for _, importDef := range g.TopDefs[name].Imports {
+			if !imported[importDef.Namespace] && namespaces[importDef.Namespace] == nil {
+				imported[importDef.Namespace] = true
+				waiting[name]++
+				dependents[importDef.Namespace] = append(dependents[importDef.Namespace], name)
+			}
+		}
+		if waiting[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	type compileJob struct {
+		Name    NSNameFull
+		Imports map[NSNameFull]*Namespace // the compiled imports (namespaces itself is written only by this goroutine)
+	}
+	jobs := make(chan compileJob)
+	results := make(chan compileResult)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for job := range jobs {
+				ns, err := g.compileOne(job.Name, job.Imports, nsFileLookup)
+				results <- compileResult{job.Name, ns, err}
+			}
+		}()
+	}
+	defer close(jobs)
+
+	errs := map[NSNameFull]error{}
+	outstanding := 0
+	for len(ready) > 0 || outstanding > 0 {
+		var send chan compileJob // nil (so never ready) when there is nothing to send
+		var next compileJob
+		if len(ready) > 0 {
+			send = jobs
+			next = compileJob{ready[0], map[NSNameFull]*Namespace{}}
+			for _, importDef := range g.TopDefs[ready[0]].Imports {
+				next.Imports[importDef.Namespace] = namespaces[importDef.Namespace]
+			}
+		}
+		select {
+		case send <- next:
+			ready = ready[1:]
+			outstanding++
+		case result := <-results:
+			outstanding--
+			if result.Err != nil {
+				// the dependents are never compiled
+				errs[result.Name] = result.Err
+				break
+			}
+			namespaces[result.Name] = result.Namespace
+			for _, dependent := range dependents[result.Name] {
+				waiting[dependent]--
+				if waiting[dependent] == 0 {
+					ready = append(ready, dependent)
+				}
+			}
+		}
+	}
+	for _, name := range g.Order {
+		if errs[name] != nil {
+			return errs[name]
+		}
+	}
+	return nil
+}
+
+// creates the namespace (from the cache if neither its source nor its imported APIs have changed)
+// and, unless from the cache, writes its C# and its cache entry
+func (g *importGraph) compileOne(name NSNameFull, imports map[NSNameFull]*Namespace,
+	nsFileLookup map[NSNameFull][]string) (*Namespace, error) {
+	topDefs := g.TopDefs[name]
+	entry := g.Cached[name]
+	if entry != nil && entry.isCurrent(imports, g.Options) {
+		ns, err := createNamespace(entry.Summary, name, imports)
+		if err != nil {
+			return nil, err
+		}
+		ns.APIHash = entry.APIHash
+		ns.Options = g.Options
+		for _, w := range entry.Warnings {
+			ns.Warnings = append(ns.Warnings, errors.New(w))
+		}
+		return ns, nil
+	}
+	if entry != nil {
+		// the summary lacks the bodies
+		var err error
+		topDefs, err = readNamespace(nsFileLookup[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+	ns, err := createNamespace(topDefs, name, imports)
+	if err != nil {
+		return nil, err
+	}
+	ns.Options = g.Options
+
+	var outputs []string
+	if !g.Options.Stubs[name] {
+		files, err := codeGen(topDefs, ns, g.Options.Split)
+		if err != nil {
+			return nil, err
+		}
+		files, err = withSourceMaps(files)
+		if err != nil {
+			return nil, err
+		}
+		if g.Options.WarningsAsErrors && len(ns.Warnings) > 0 {
+			s := "Warnings treated as errors in namespace " + string(name) + ":"
+			for _, w := range ns.Warnings {
+				s += "\n" + w.Error()
+			}
+			return nil, errors.New(s)
+		}
+		outputs, err = writeOutputs(files, g.Options.OutDir, g.LastOutputs[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summary := summarize(topDefs)
+	ns.APIHash, err = hashAPI(summary, imports)
+	if err != nil {
+		return nil, err
+	}
+	entry = &cacheEntry{
+		SourceHash:   g.SourceHashes[name],
+		APIHash:      ns.APIHash,
+		ImportHashes: map[NSNameFull]string{},
+		Summary:      summary,
+		Options:      g.Options,
+		Outputs:      outputs,
+	}
+	for _, importDef := range topDefs.Imports {
+		entry.ImportHashes[importDef.Namespace] = imports[importDef.Namespace].APIHash
+	}
+	for _, w := range ns.Warnings {
+		entry.Warnings = append(entry.Warnings, w.Error())
+	}
+	return ns, writeCacheEntry(name, entry)
+}
+
+// writes the files of the namespace (each in full or not at all) and removes the files written by its
+// last compilation which were not written this time, e.g. of a type since removed (any other file in the
+// directory is left alone, whatever its name); returns the paths written
+func writeOutputs(files []outputFile, dir string, lastOutputs []string) ([]string, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	written := map[string]bool{}
+	var paths []string
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name)
+		err := writeFileAtomic(path, []byte(file.Code))
+		if err != nil {
+			return nil, err
+		}
+		written[path] = true
+		paths = append(paths, path)
+	}
+	for _, path := range lastOutputs {
+		if written[path] {
+			continue
+		}
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// writes to a temporary file in the same directory which replaces the file only once complete
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// reads and parses the source files of a namespace one after another
+func readNamespace(files []string) (*TopDefs, error) {
+	defs := make([]*TopDefs, len(files))
+	errs := make([]error, len(files))
+	for i, file := range files {
+		defs[i], errs[i] = parseFile(file, i == 0)
+		if errs[i] != nil {
+			break
+		}
+	}
+	return mergeTopDefs(defs, errs)
+}
+
+// lexes, reads and parses a source file (whose first line, the namespace name, is skipped)
+func parseFile(file string, isMain bool) (*TopDefs, error) {
+	topDefs := &TopDefs{
+		Classes: []ClassDef{},
+		Structs: []StructDef{},
+		Funcs:   []FuncDef{},
+		Globals: []GlobalDef{},
+		Imports: []ImportDef{},
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(data) {
+		return nil, errors.New("Source file is not valid UTF-8: " + file)
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+	data = append(data, '\n', '\n', '\n', '\n')
+
+	// find first blank line
+	var blankIdx int
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\n' && data[i+1] == '\n' {
+			blankIdx = i
+			break
+		}
+		if data[i] == '\r' && data[i+1] == '\n' && data[i+2] == '\r' && data[i+3] == '\n' {
+			blankIdx = i
+			break
+		}
+	}
+	if blankIdx == 0 {
+		return nil, errors.New("Expecting blank line in file after namespace name: " + file)
+	}
+
+	data = data[blankIdx:]
+
+	tokens, err := lex(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	atoms, err := read(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	err = parse(atoms, topDefs, isMain)
+	if err != nil {
+		return nil, err
+	}
+	setSourceFile(topDefs, filepath.ToSlash(file))
+	return topDefs, nil
+}
+
+// records in each definition the file declaring it (the namespace's definitions are merged from all its files)
+func setSourceFile(topDefs *TopDefs, file string) {
+	for i := range topDefs.Classes {
+		topDefs.Classes[i].File = file
+	}
+	for i := range topDefs.Structs {
+		topDefs.Structs[i].File = file
+	}
+	for i := range topDefs.Interfaces {
+		topDefs.Interfaces[i].File = file
+	}
+	for i := range topDefs.Funcs {
+		topDefs.Funcs[i].File = file
+	}
+	for i := range topDefs.Globals {
+		topDefs.Globals[i].File = file
+	}
+}
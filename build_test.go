@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportGraphOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		imports    map[NSNameFull][]NSNameFull // of each namespace in the graph
+		loadErrors map[NSNameFull]string
+		compiled   []NSNameFull // by an earlier build, e.g. of another root
+		want       []NSNameFull
+		err        string
+	}{
+		{
+			name:    "chain",
+			imports: map[NSNameFull][]NSNameFull{"a": {"b"}, "b": {"c"}, "c": {}},
+			want:    []NSNameFull{"c", "b", "a"},
+		},
+		{
+			name: "diamond",
+			imports: map[NSNameFull][]NSNameFull{
+				"a": {"b", "c"},
+				"b": {"d"},
+				"c": {"d"},
+				"d": {},
+			},
+			want: []NSNameFull{"d", "b", "c", "a"},
+		},
+		{
+			name:     "compiled namespaces left out",
+			imports:  map[NSNameFull][]NSNameFull{"a": {"b", "c"}, "b": {"c"}},
+			compiled: []NSNameFull{"c"},
+			want:     []NSNameFull{"b", "a"},
+		},
+		{
+			name:    "cycle reported with its chain",
+			imports: map[NSNameFull][]NSNameFull{"a": {"b"}, "b": {"c"}, "c": {"b"}},
+			err:     "Recursive import dependency: a imports b imports c imports b",
+		},
+		{
+			name:    "self import",
+			imports: map[NSNameFull][]NSNameFull{"a": {"a"}},
+			err:     "Recursive import dependency: a imports a",
+		},
+		{
+			name:       "load error reported when reached",
+			imports:    map[NSNameFull][]NSNameFull{"a": {"b"}, "b": {}},
+			loadErrors: map[NSNameFull]string{"b": "b.bf: cannot read"},
+			err:        "b.bf: cannot read",
+		},
+	}
+	for _, test := range tests {
+		namespaces := map[NSNameFull]*Namespace{}
+		for _, name := range test.compiled {
+			namespaces[name] = &Namespace{Name: name}
+		}
+		g := newImportGraph(namespaces, buildOptions{})
+		for name, imports := range test.imports {
+			topDefs := &TopDefs{}
+			for _, imported := range imports {
+				topDefs.Imports = append(topDefs.Imports, ImportDef{Namespace: imported})
+			}
+			g.TopDefs[name] = topDefs
+		}
+		for name, s := range test.loadErrors {
+			g.LoadErrors[name] = errors.New(s)
+		}
+		err := g.order("a", nil)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(g.Order, test.want) {
+			t.Errorf("%s: got order %v, expected %v", test.name, g.Order, test.want)
+		}
+	}
+}
+
+func TestWriteOutputs(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"out/example.cs":             "old",
+		"out/example.Dog.cs":         "old",
+		"out/example.Handwritten.cs": "by hand",
+	})
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	last := []string{
+		filepath.Join(out, "example.cs"),
+		filepath.Join(out, "example.Dog.cs"),
+		filepath.Join(out, "example.Gone.cs"), // already deleted
+	}
+	files := []outputFile{{"example.cs", "new"}, {"example.Cat.cs", "new"}}
+	paths, err := writeOutputs(files, out, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(out, "example.cs"), filepath.Join(out, "example.Cat.cs")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got paths %v, expected %v", paths, want)
+	}
+	infos, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	// (ReadDir sorts by name)
+	wantNames := []string{"example.Cat.cs", "example.Handwritten.cs", "example.cs"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("got files %v, expected %v", names, wantNames)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+// the build cache: for each namespace compiled, a summary of its declarations (its public API without the bodies)
+// keyed by a hash of its source files, so a namespace whose source and imported APIs are unchanged
+// is recreated from its summary rather than lexed, parsed and compiled again
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	runtimedebug "runtime/debug"
+)
+
+const cacheDir = ".bflatcache"
+
+// changed whenever the format of the entries changes, invalidating every entry
+// (a change of the compiler output invalidates them anyway, as the compiler build differs)
+const cacheVersion = "3"
+
+// identifies the build of the compiler: the revision it was built from if its checkout was clean,
+// or else a hash of the executable itself
+var compilerBuild = findCompilerBuild()
+
+func findCompilerBuild() string {
+	if info, ok := runtimedebug.ReadBuildInfo(); ok {
+		revision, modified := "", ""
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value
+			}
+		}
+		if revision != "" && modified == "false" {
+			return revision
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	data, err := ioutil.ReadFile(exe)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type cacheEntry struct {
+	SourceHash   string
+	APIHash      string
+	ImportHashes map[NSNameFull]string // the API hash of each import when the namespace was compiled
+	Summary      *TopDefs
+	Options      buildOptions // with which the C# was generated
+	Outputs      []string     // files of the generated C#
+	Warnings     []string
+}
+
+// hash of the source files of a namespace (and of the compiler build)
+func hashSources(files []string) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(cacheVersion + "\x00" + compilerBuild + "\x00"))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(file + "\x00"))
+		h.Write(data)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func cacheFilename(namespace NSNameFull) string {
+	return filepath.Join(cacheDir, string(namespace)+".json")
+}
+
+// nil if the namespace has no readable entry
+func readCacheEntry(namespace NSNameFull) *cacheEntry {
+	data, err := ioutil.ReadFile(cacheFilename(namespace))
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if json.Unmarshal(data, entry) != nil || entry.Summary == nil {
+		return nil // a corrupt entry is just a miss
+	}
+	return entry
+}
+
+func writeCacheEntry(namespace NSNameFull, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(cacheDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cacheFilename(namespace), data)
+}
+
+// true if the entry's output was generated with the options and still exists,
+// and every import has the API it had when the entry was written (the imports must already be in namespaces)
+func (entry *cacheEntry) isCurrent(namespaces map[NSNameFull]*Namespace, options buildOptions) bool {
+	if !reflect.DeepEqual(entry.Options, options) {
+		return false
+	}
+	for _, output := range entry.Outputs {
+		if _, err := os.Stat(output); err != nil {
+			return false
+		}
+	}
+	for _, importDef := range entry.Summary.Imports {
+		foreign := namespaces[importDef.Namespace]
+		if foreign == nil || foreign.APIHash == "" || foreign.APIHash != entry.ImportHashes[importDef.Namespace] {
+			return false
+		}
+	}
+	return true
+}
+
+// the public surface of the declarations: without the private types and members, bodies, initial values and annotations,
+// which neither the importers nor createNamespace for them need (so a change to any of them leaves the API hash unchanged)
+func summarize(topDefs *TopDefs) *TopDefs {
+	summary := &TopDefs{
+		Classes:    make([]ClassDef, 0, len(topDefs.Classes)),
+		Structs:    make([]StructDef, 0, len(topDefs.Structs)),
+		Interfaces: make([]InterfaceDef, 0, len(topDefs.Interfaces)),
+		Funcs:      make([]FuncDef, len(topDefs.Funcs)),
+		Globals:    make([]GlobalDef, len(topDefs.Globals)),
+		Imports:    topDefs.Imports,
+	}
+	for _, def := range topDefs.Classes {
+		if def.AccessLevel == PrivateAccess {
+			continue
+		}
+		def.Annotations = nil
+		def.Fields = summarizeFields(def.Fields)
+		def.Methods = summarizeMethods(def.Methods)
+		def.Constructors = summarizeConstructors(def.Constructors)
+		def.Properties = summarizeProperties(def.Properties)
+		def.Indexers = summarizeIndexers(def.Indexers)
+		summary.Classes = append(summary.Classes, def)
+	}
+	for _, def := range topDefs.Structs {
+		if def.AccessLevel == PrivateAccess {
+			continue
+		}
+		def.Annotations = nil
+		def.Fields = summarizeFields(def.Fields)
+		def.Methods = summarizeMethods(def.Methods)
+		def.Constructors = summarizeConstructors(def.Constructors)
+		def.Properties = summarizeProperties(def.Properties)
+		def.Indexers = summarizeIndexers(def.Indexers)
+		summary.Structs = append(summary.Structs, def)
+	}
+	for _, def := range topDefs.Interfaces {
+		if def.AccessLevel == PrivateAccess {
+			continue
+		}
+		def.Annotations = nil
+		def.MethodAnnotations = nil
+		def.Properties = summarizeProperties(def.Properties)
+		summary.Interfaces = append(summary.Interfaces, def)
+	}
+	for i, def := range topDefs.Funcs {
+		def.Annotations = nil
+		def.Body = nil
+		def.Helpers = nil // private to the function
+		summary.Funcs[i] = def
+	}
+	for i, def := range topDefs.Globals {
+		def.Annotations = nil
+		def.Value = nil
+		summary.Globals[i] = def
+	}
+	return summary
+}
+
+func summarizeFields(fields []FieldDef) []FieldDef {
+	summary := make([]FieldDef, 0, len(fields))
+	for _, def := range fields {
+		if def.AccessLevel == PrivateAccess {
+			continue
+		}
+		def.Annotations = nil
+		def.Value = nil
+		summary = append(summary, def)
+	}
+	return summary
+}
+
+func summarizeMethods(methods []MethodDef) []MethodDef {
+	summary := make([]MethodDef, len(methods))
+	for i, def := range methods {
+		def.Annotations = nil
+		def.Body = nil
+		summary[i] = def
+	}
+	return summary
+}
+
+func summarizeConstructors(constructors []ConstructorDef) []ConstructorDef {
+	summary := make([]ConstructorDef, len(constructors))
+	for i, def := range constructors {
+		def.Annotations = nil
+		def.Body = nil
+		summary[i] = def
+	}
+	return summary
+}
+
+func summarizeProperties(properties []PropertyDef) []PropertyDef {
+	summary := make([]PropertyDef, 0, len(properties))
+	for _, def := range properties {
+		if def.AccessLevel == PrivateAccess {
+			continue
+		}
+		def.Annotations = nil
+		def.GetBody = nil
+		def.SetBody = nil
+		summary = append(summary, def)
+	}
+	return summary
+}
+
+func summarizeIndexers(indexers []IndexerDef) []IndexerDef {
+	summary := make([]IndexerDef, 0, len(indexers))
+	for _, def := range indexers {
+		if def.AccessLevel == PrivateAccess {
+			continue
+		}
+		def.Annotations = nil
+		def.GetBody = nil
+		def.SetBody = nil
+		summary = append(summary, def)
+	}
+	return summary
+}
+
+// hash of the summary (ignoring the positions and files of the declarations, which are not part of the API)
+// and of the API hashes of the imports, whose types the summary may use
+func hashAPI(summary *TopDefs, namespaces map[NSNameFull]*Namespace) (string, error) {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return "", err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		return "", err
+	}
+	// (json encodes the keys of maps in sorted order, so the encoding is canonical)
+	data, err = json.Marshal(withoutPositions(decoded))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+	for _, importDef := range summary.Imports {
+		h.Write([]byte("\x00" + string(importDef.Namespace) + "\x00" + namespaces[importDef.Namespace].APIHash))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// the decoded json without the Line, Column and File fields of its objects
+func withoutPositions(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "Line")
+		delete(v, "Column")
+		delete(v, "File")
+		for key, elem := range v {
+			v[key] = withoutPositions(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = withoutPositions(elem)
+		}
+	}
+	return v
+}
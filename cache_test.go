@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const hashedSource = `example
+
+(import other)
+
+(class Dog
+    (f name Str)
+    (m bark Str
+        (return ` + "`woof`" + `)))
+
+(func count I : d Dog
+    (return 1))
+`
+
+// the API hash of the source, whose import has the API hash given
+func apiHashOf(t *testing.T, source string, importHash string) string {
+	dir := writeTree(t, map[string]string{"example.bf": source})
+	defer os.RemoveAll(dir)
+	topDefs, err := parseFile(filepath.Join(dir, "example.bf"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hashAPI(summarize(topDefs), map[NSNameFull]*Namespace{"other": {APIHash: importHash}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestHashAPI(t *testing.T) {
+	base := apiHashOf(t, hashedSource, "x")
+	tests := []struct {
+		name       string
+		source     string
+		importHash string
+		same       bool // the API is unchanged
+	}{
+		{"declarations moved", "example\n\n\n\n" + hashedSource[len("example\n\n"):], "x", true},
+		{"bodies changed", replace(t, hashedSource, "(return 1)", "(return 2)"), "x", true},
+		{"private class added", hashedSource + "\n(class -priv Cat\n    (f lives I))\n", "x", true},
+		{"public class added", hashedSource + "\n(class Cat\n    (f lives I))\n", "x", false},
+		{"field added", replace(t, hashedSource, "(f name Str)", "(f name Str)\n    (f age I)"), "x", false},
+		{"parameter type changed", replace(t, hashedSource, ": d Dog", ": d Str"), "x", false},
+		{"import API changed", hashedSource, "y", false},
+	}
+	for _, test := range tests {
+		hash := apiHashOf(t, test.source, test.importHash)
+		if (hash == base) != test.same {
+			t.Errorf("%s: API hash changed is %v, expected %v", test.name, hash != base, !test.same)
+		}
+	}
+}
+
+// the source with its only occurrence of old replaced
+func replace(t *testing.T, source string, old string, new string) string {
+	if strings.Count(source, old) != 1 {
+		t.Fatalf("expected one occurrence of %q", old)
+	}
+	return strings.Replace(source, old, new, 1)
+}
+
+func TestCacheEntryIsCurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bflat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "example.cs")
+	err = ioutil.WriteFile(output, []byte("namespace Example {}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := buildOptions{OutDir: dir}
+	tests := []struct {
+		name       string
+		options    buildOptions
+		outputs    []string
+		namespaces map[NSNameFull]*Namespace
+		want       bool
+	}{
+		{"unchanged", options, []string{output}, map[NSNameFull]*Namespace{"other": {APIHash: "x"}}, true},
+		{"options changed", buildOptions{OutDir: dir, Split: true}, []string{output},
+			map[NSNameFull]*Namespace{"other": {APIHash: "x"}}, false},
+		{"output deleted", options, []string{output, filepath.Join(dir, "example.Dog.cs")},
+			map[NSNameFull]*Namespace{"other": {APIHash: "x"}}, false},
+		{"import API changed", options, []string{output}, map[NSNameFull]*Namespace{"other": {APIHash: "y"}}, false},
+		{"import API unknown", options, []string{output}, map[NSNameFull]*Namespace{"other": {}}, false},
+		{"import not compiled", options, []string{output}, map[NSNameFull]*Namespace{}, false},
+	}
+	for _, test := range tests {
+		entry := &cacheEntry{
+			ImportHashes: map[NSNameFull]string{"other": "x"},
+			Summary:      &TopDefs{Imports: []ImportDef{{Namespace: "other"}}},
+			Options:      options,
+			Outputs:      test.outputs,
+		}
+		got := entry.isCurrent(test.namespaces, test.options)
+		if got != test.want {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
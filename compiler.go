@@ -2,156 +2,171 @@ package main
 
 import (
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"math"
-	"os"
 	"strconv"
 	"strings"
 )
 
-func codeGen(topDefs *TopDefs, ns *Namespace) (string, error) {
-	code := "namespace " + string(ns.CSName) + " {\n\n"
+// a generated C# file
+type outputFile struct {
+	Name string // relative to the output directory
+	Code string
+}
+
+// the globals and funcs go in <namespace>.cs, as do the types unless split,
+// in which case each type goes in <namespace>.<type>.cs
+func codeGen(topDefs *TopDefs, ns *Namespace, split bool) ([]outputFile, error) {
+	header := func(w *codeWriter) {
+		for _, define := range ns.Options.Defines {
+			w.directive("#define " + define)
+		}
+		w.open("namespace " + string(ns.CSName))
+	}
+	w := &codeWriter{}
+	header(w)
 
-	c, err := compileGlobals(topDefs.Globals, ns, "\t")
+	err := compileGlobals(topDefs.Globals, ns, w)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	code += c
 
-	code += "public class " + FuncsClass + " {\n"
+	w.blankLine()
+	w.open("public class " + FuncsClass)
 	for _, fn := range topDefs.Funcs {
-		c, err := compileFunc(fn, ns, "\t")
+		ns.SourceFile = fn.File
+		w.blankLine()
+		err := compileFunc(fn, ns, w)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		code += c
 	}
-	code += "}\n\n"
+	w.directive(lineDefault)
+	w.close()
 
-	for _, classDef := range topDefs.Classes {
-		c, err := compileClass(classDef, ns, "")
-		if err != nil {
-			return "", err
+	var files []outputFile
+	// the writer of a type: that of <namespace>.cs, or if split, a writer of the type's own file
+	typeWriter := func() *codeWriter {
+		if !split {
+			w.blankLine()
+			return w
 		}
-		code += c
-	}
-
-	for _, interfaceDef := range topDefs.Interfaces {
-		c, err := compileInterface(interfaceDef, ns, "")
-		if err != nil {
-			return "", err
+		tw := &codeWriter{}
+		header(tw)
+		return tw
+	}
+	addType := func(name ShortName, tw *codeWriter) {
+		tw.directive(lineDefault)
+		if split {
+			tw.close()
+			files = append(files, outputFile{string(ns.Name) + "." + string(name) + ".cs", tw.String()})
 		}
-		code += c
 	}
 
-	code += "}"
-
-	return code, nil
-}
-
-func getNSNameShort(namespace NSNameFull) NSNameShort {
-	return NSNameShort(namespace[strings.LastIndex(string(namespace), ".")+1:])
-}
-
-func compileNamespace(namespace NSNameFull, nsFileLookup map[NSNameFull][]string, namespaces map[NSNameFull]*Namespace) error {
-	if _, ok := namespaces[namespace]; ok {
-		return errors.New("Recursive import depedency: " + string(namespace))
-	}
-
-	topDefs := &TopDefs{
-		Classes: []ClassDef{},
-		Structs: []StructDef{},
-		Funcs:   []FuncDef{},
-		Globals: []GlobalDef{},
-		Imports: []ImportDef{},
-	}
-
-	if len(nsFileLookup[namespace]) == 0 {
-		return errors.New("No source files found for namespace: " + string(namespace))
-	}
-
-	for i, file := range nsFileLookup[namespace] {
-
-		data, err := ioutil.ReadFile(file)
+	for _, classDef := range topDefs.Classes {
+		ns.SourceFile = classDef.File
+		tw := typeWriter()
+		err := compileClass(classDef, ns, tw)
 		if err != nil {
-			return err
-		}
-		data = append(data, '\n', '\n', '\n', '\n')
-
-		// find first blank line
-		var blankIdx int
-		for i := 0; i < len(data); i++ {
-			if data[i] == '\n' && data[i+1] == '\n' {
-				blankIdx = i
-				break
-			}
-			if data[i] == '\r' && data[i+1] == '\n' && data[i+2] == '\r' && data[i+3] == '\n' {
-				blankIdx = i
-				break
-			}
+			return nil, err
 		}
-		if blankIdx == 0 {
-			return errors.New("Expecting blank line in file after namespace name: " + file)
-		}
-
-		data = data[blankIdx:]
+		addType(classDef.Type.Name, tw)
+	}
 
-		tokens, err := lex(string(data))
+	for _, structDef := range topDefs.Structs {
+		ns.SourceFile = structDef.File
+		tw := typeWriter()
+		err := compileStruct(structDef, ns, tw)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		addType(structDef.Type.Name, tw)
+	}
 
-		atoms, err := read(tokens)
+	for _, interfaceDef := range topDefs.Interfaces {
+		ns.SourceFile = interfaceDef.File
+		tw := typeWriter()
+		err := compileInterface(interfaceDef, ns, tw)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		addType(interfaceDef.Type.Name, tw)
+	}
 
-		err = parse(atoms, topDefs, i == 0)
-		if err != nil {
-			return err
-		}
+	w.close()
 
+	files = append([]outputFile{{string(ns.Name) + ".cs", w.String()}}, files...)
+	for i := range files {
+		files[i].Code = pruneLineDirectives(files[i].Code)
 	}
+	return files, nil
+}
 
-	ns, err := createNamespace(topDefs, namespace, nsFileLookup, namespaces)
-	if err != nil {
-		return err
-	}
-	namespaces[namespace] = ns
+// ends the C# attributed to the source by the last #line directive
+const lineDefault = "#line default"
 
-	code, err := codeGen(topDefs, ns)
-	if err != nil {
-		return err
+// a #line directive attributing the C# which follows to the line of the definition's source file,
+// so the C# compiler's errors (and the debugger) refer to the source rather than the generated code
+func lineDirective(ns *Namespace, line int) string {
+	if ns.SourceFile == "" || line <= 0 {
+		return ""
 	}
+	return "#line " + strconv.Itoa(line) + " \"" + ns.SourceFile + "\""
+}
 
-	outputFilename := string(namespace) + ".cs"
-	err = ioutil.WriteFile(outputFilename, []byte(code), os.ModePerm)
+func getNSNameShort(namespace NSNameFull) NSNameShort {
+	return NSNameShort(namespace[strings.LastIndex(string(namespace), ".")+1:])
+}
+
+// compiles the namespace and, before it, every namespace it imports directly or indirectly
+// (a namespace already in namespaces is not compiled again)
+func compileNamespace(namespace NSNameFull, nsFileLookup map[NSNameFull][]string, namespaces map[NSNameFull]*Namespace,
+	options buildOptions) error {
+	graph := newImportGraph(namespaces, options)
+	graph.load(namespace, nsFileLookup)
+	err := graph.order(namespace, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	return graph.compile(nsFileLookup, namespaces)
 }
 
-// returns indexed type and true if an indexable type
+// returns indexed type and true if an array type
+// (classes and structs are indexed through their indexers: see GetIndexers)
 func IsIndexableType(t Type) (Type, bool) {
 	switch t := t.(type) {
 	case ArrayType:
 		return t.BaseType, true
-	case *ClassInfo:
-		// todo
-		return nil, false
-	case *StructInfo:
-		// todo
-		return nil, false
-	case *InterfaceInfo:
-		return nil, false
-	case BuiltinType:
-		return nil, false
 	default:
 		return nil, false
 	}
 }
 
+// returns the index of the param list whose every number param equals or widens to
+// the corresponding params of all the other lists (the best match of an overloaded call, as in C#),
+// or -1 if there is no such list; assumes the lists are all of the same length
+func narrowestParams(paramLists [][]Type) int {
+Loop:
+	for i, params := range paramLists {
+		for k, other := range paramLists {
+			same := true
+			for j, t := range params {
+				if t != other[j] {
+					same = false
+					if !IsNumberWidening(t, other[j]) {
+						continue Loop
+					}
+				}
+			}
+			if same && k != i {
+				return -1
+			}
+		}
+		return i
+	}
+	return -1
+}
+
 // only first param matters,
 // assumes len(sigs) >= 2
 // we can assume that all sigs have at least one param
@@ -211,110 +226,53 @@ func compileExpression(expr Expression, ns *Namespace, expectedType Type,
 	locals map[ShortName]Type) (code string, dt Type, err error) {
 	switch expr := expr.(type) {
 	case VarExpression:
+		if expr.Namespace == "" && isLiteralWord(string(expr.Name)) {
+			code, dt = compileLiteralWord(expr.Name)
+			break
+		}
 		global := ns.GetGlobal(expr.Name, expr.Namespace)
 		if global != nil {
 			dt = global.Type
 			code = string(global.Namespace.CSName) + "." + GlobalsClass + "." + string(global.Name)
-			return
 		} else {
 			var ok bool
 			dt, ok = locals[expr.Name]
 			if !ok {
 				return "", nil, msg(expr.Line, expr.Column, "No variable found of name: "+string(expr.Name))
 			}
+			dt, err = localType(expr.Name, dt, false, expr.Line, expr.Column)
+			if err != nil {
+				return "", nil, err
+			}
 			if expr.Name == thisWord {
 				code = "this"
 			} else {
 				code = string(expr.Name)
 			}
-			return
 		}
 	case ParsedNumberAtom:
-		if expectedType == nil {
-			if expr.FractionalPart == "" {
-				val, err := strconv.Atoi(expr.IntegerPart)
-				if err != nil {
-					return "", nil, msg(expr.Line, expr.Column, "Expecting Int number literal.")
-				}
-				if val > math.MaxInt32 || val < math.MinInt32 {
-					return "", nil, msg(expr.Line, expr.Column, "Expecting Int number literal but magnitude is too great.")
-				}
-				code = expr.IntegerPart
-				dt = IntType
-			} else {
-				code = "(double) " + expr.IntegerPart + "." + expr.FractionalPart
-				dt = DoubleType
-			}
-		} else if expectedType == IntType {
-			if expr.FractionalPart != "" {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Int literal, but got floating-point.")
-			}
-			val, err := strconv.Atoi(expr.IntegerPart)
-			if err != nil {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Int number literal.")
-			}
-			if val > math.MaxInt32 || val < math.MinInt32 {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Int number literal but magnitude is too great.")
-			}
-			code = expr.IntegerPart
-			dt = IntType
-		} else if expectedType == LongType {
-			if expr.FractionalPart != "" {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting II literal, but got floating-point.")
-			}
-			_, err := strconv.Atoi(expr.IntegerPart)
-			if err != nil {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting II number literal, but value is not integer or out of range.")
-			}
-			code = "(long) " + expr.IntegerPart
-			dt = LongType
-		} else if expectedType == FloatType {
-			if expr.FractionalPart == "" {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Float literal, but got integer.")
-			}
-			code = "(float) " + expr.IntegerPart + "." + expr.FractionalPart
-			dt = FloatType
-		} else if expectedType == DoubleType {
-			// todo check within double range
-			code = "(double) " + expr.IntegerPart + "." + expr.FractionalPart
-			dt = DoubleType
-		} else if expectedType == ByteType {
-			if expr.FractionalPart != "" {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Byte literal, but got floating-point.")
-			}
-			val, err := strconv.Atoi(expr.IntegerPart)
-			if err != nil {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Byte number literal.")
-			}
-			if val > math.MaxUint8 || val < 0 {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting Byte number literal but value is out of range.")
-			}
-			code = "(byte) " + expr.IntegerPart
-			dt = ByteType
-		} else if expectedType == SignedByteType {
-			if expr.FractionalPart != "" {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting SByte literal, but got floating-point.")
-			}
-			val, err := strconv.Atoi(expr.IntegerPart)
-			if err != nil {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting SByte number literal.")
-			}
-			if val > math.MaxInt8 || val < math.MinInt8 {
-				return "", nil, msg(expr.Line, expr.Column, "Expecting SByte number literal but value is out of range.")
-			}
-			code = "(sbyte) " + expr.IntegerPart
-			dt = SignedByteType
-		} else {
-			return "", nil, msg(expr.Line, expr.Column, "Non-number type given as expected type for a number literal.")
+		code, dt, err = compileNumberLiteral(expr, expectedType)
+		if err != nil {
+			return "", nil, err
 		}
 	case StringAtom:
-		code = "\"" + escapeString(expr.Content[1:len(expr.Content)-1]) + "\""
+		code = "\"" + escapeString(stringValue(expr.Content)) + "\""
 		dt = StrType
+	case InterpolatedStringForm:
+		code, err = compileInterpolatedString(expr, ns, locals)
+		if err != nil {
+			return "", nil, err
+		}
+		dt = StrType
+	case CharAtom:
+		code = expr.Content
+		dt = CharType
 	case CallForm:
 		code, dt, err = compileCallForm(expr, ns, expectedType, locals)
 		if err != nil {
 			return "", nil, err
 		}
+		ns.ValueTypes[ns.position(expr.Line, expr.Column, "")] = dt
 	case TypeCallForm:
 		code, dt, err = compileTypeCallForm(expr, ns, expectedType, locals)
 		if err != nil {
@@ -325,62 +283,263 @@ func compileExpression(expr Expression, ns *Namespace, expectedType Type,
 		if err != nil {
 			return "", nil, err
 		}
+		ns.ValueTypes[ns.position(expr.Line, expr.Column, "")] = dt
 	default:
 		return "", nil, errors.New("Unexpected non-expression: line " +
 			itoa(expr.GetLine()) + " column " + itoa(expr.GetColumn()))
 	}
 	if expectedType != nil && !IsSubType(dt, expectedType) {
+		if dt == NilType {
+			return "", nil, msg(expr.GetLine(), expr.GetColumn(), "nil is not a value of a non-nullable type (e.g. Dog is not nullable, but Dog? is).")
+		}
+		if _, ok := dt.(NullableType); ok && IsSubType(NonNullable(dt), expectedType) {
+			return "", nil, msg(expr.GetLine(), expr.GetColumn(), "A nullable value type requires an explicit cast (or coalesce) to be non-nullable.")
+		}
+		if IsCastable(dt, expectedType) && (IsNumber(dt) || dt == CharType) && (IsNumber(expectedType) || expectedType == CharType) {
+			conversion := "Converting "
+			if IsNumberWidening(expectedType, dt) {
+				conversion = "Narrowing "
+			}
+			return "", nil, msg(expr.GetLine(), expr.GetColumn(), conversion+string(dt.(BuiltinType).Name)+
+				" to "+string(expectedType.(BuiltinType).Name)+" requires an explicit cast.")
+		}
 		return "", nil, msg(expr.GetLine(), expr.GetColumn(), "Expression has wrong type.")
 	}
 	return
 }
 
-func compileGlobals(globals []GlobalDef, ns *Namespace, indent string) (string, error) {
-	code := "public class " + GlobalsClass + " {\n"
+// a literal with a suffix has the type of its suffix, else it takes the expected number type; otherwise an integer literal
+// is I (or II, or else UII, if too great for I) and a fractional literal is FF
+func compileNumberLiteral(expr ParsedNumberAtom, expectedType Type) (string, Type, error) {
+	v := expr.Value
+	expectedType = NonNullable(expectedType)
+	if v.Suffix != "" {
+		expectedType = numberTypeByName(v.Suffix)
+	} else if !IsNumber(expectedType) {
+		if expectedType != nil && expectedType != AnyType {
+			return "", nil, msg(expr.Line, expr.Column, "Non-number type given as expected type for a number literal.")
+		}
+		if v.Fractional {
+			expectedType = DoubleType
+		} else {
+			expectedType = IntType
+			for _, t := range []Type{IntType, LongType, UnsignedLongType} {
+				if !v.Overflow && integerRanges[t.(BuiltinType).Name].contains(v.Negative, v.Magnitude) {
+					expectedType = t
+					break
+				}
+			}
+		}
+	}
+	name := string(expectedType.(BuiltinType).Name)
+	if IsInteger(expectedType) {
+		if v.Fractional {
+			return "", nil, msg(expr.Line, expr.Column, "Expecting "+name+" literal, but got floating-point.")
+		}
+		if v.Overflow || !integerRanges[expectedType.(BuiltinType).Name].contains(v.Negative, v.Magnitude) {
+			return "", nil, msg(expr.Line, expr.Column, "Number literal is out of range for "+name+".")
+		}
+	} else {
+		val := v.Float
+		if !v.Fractional {
+			val = float64(v.Magnitude)
+		}
+		limit := math.MaxFloat64
+		if expectedType == FloatType {
+			limit = math.MaxFloat32
+		} else if expectedType == DecimalType {
+			limit = maxDecimal
+		}
+		if v.Overflow || math.IsInf(val, 0) || math.Abs(val) > limit {
+			return "", nil, msg(expr.Line, expr.Column, "Number literal is out of range for "+name+".")
+		}
+	}
+	if expectedType == IntType && v.Radix == 10 {
+		return v.Text, IntType, nil
+	}
+	if expectedType == DecimalType && v.Radix == 10 {
+		return v.Text + "m", DecimalType, nil
+	}
+	return "(" + compileType(expectedType) + ") " + v.Text, expectedType, nil
+}
+
+// the code and type of nil, true or false
+func compileLiteralWord(word ShortName) (string, Type) {
+	switch word {
+	case nilWord:
+		return "null", NilType
+	case trueWord:
+		return "true", BoolType
+	}
+	return "false", BoolType
+}
+
+// the greatest magnitude of a C# decimal
+const maxDecimal = 7.9228162514264337593543950335e28
+
+func (r integerRange) contains(negative bool, magnitude uint64) bool {
+	if !negative || magnitude == 0 {
+		return magnitude <= r.Max
+	}
+	// the magnitude of the least value is -(Min+1)+1, which cannot overflow
+	return r.Min < 0 && magnitude-1 <= uint64(-(r.Min+1))
+}
+
+func compileGlobals(globals []GlobalDef, ns *Namespace, w *codeWriter) error {
+	w.open("public class " + GlobalsClass)
 	for _, g := range globals {
 		globalInfo := ns.Globals[g.Name]
-		code += indent + "public " + compileType(globalInfo.Type) + " " + string(g.Name)
+		ns.SourceFile = g.File
+		w.directive(lineDirective(ns, g.Line))
+		code := "public " + compileType(globalInfo.Type) + " " + string(g.Name)
 		if g.Value != nil {
 			c, returnedType, err := compileExpression(g.Value, ns, globalInfo.Type, map[ShortName]Type{})
 			if err != nil {
-				return "", err
+				return err
 			}
 			if !IsSubType(returnedType, globalInfo.Type) {
-				return "", msg(g.Line, g.Column, "Initial value of global does not match the declared type.")
+				return msg(g.Line, g.Column, "Initial value of global does not match the declared type.")
 			}
 			code += " = " + c
 		}
-		code += ";\n"
+		w.line(code + ";")
 	}
-	code += "}\n\n"
-	return code, nil
+	w.directive(lineDefault)
+	w.close()
+	return nil
+}
+
+// the value of a plain or raw string literal as written in source (including its backticks)
+func stringValue(content string) string {
+	if strings.HasPrefix(content, "@") {
+		return rawStringValue(content[2 : len(content)-1])
+	}
+	return unescapeString(content[1 : len(content)-1])
+}
+
+// resolves the escape sequences of a string literal's text (which the lexer has checked are valid);
+// a line break in source is always a newline (\n), even where the file has CRLF line endings
+func unescapeString(str string) string {
+	src := []rune(strings.Replace(str, "\r\n", "\n", -1))
+	dest := make([]rune, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\\' {
+			dest = append(dest, src[i])
+			continue
+		}
+		i++
+		switch src[i] {
+		case 'n':
+			dest = append(dest, '\n')
+		case 't':
+			dest = append(dest, '\t')
+		case 'r':
+			dest = append(dest, '\r')
+		case '0':
+			dest = append(dest, 0)
+		case 'u', 'U':
+			nDigits := 4
+			if src[i] == 'U' {
+				nDigits = 8
+			}
+			val, _ := strconv.ParseUint(string(src[i+1:i+1+nDigits]), 16, 32)
+			dest = append(dest, rune(val))
+			i += nDigits
+		default: // \\, \`, \' and \"
+			dest = append(dest, src[i])
+		}
+	}
+	return string(dest)
 }
 
+// the text of a raw string: if the text spans lines, a blank first line and a last line of only spaces
+// (i.e. the lines of the backticks) are dropped, and so are the leading spaces common to all the non-blank lines
+func rawStringValue(str string) string {
+	lines := strings.Split(strings.Replace(str, "\r\n", "\n", -1), "\n")
+	if len(lines) == 1 {
+		return str
+	}
+	if strings.TrimLeft(lines[0], " ") == "" {
+		lines = lines[1:]
+	}
+	if strings.TrimLeft(lines[len(lines)-1], " ") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent := -1
+	for _, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed != "" && (indent == -1 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i, line := range lines {
+		if len(line) < indent {
+			lines[i] = ""
+		} else if indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// the text of a C# string literal with the value of str
 func escapeString(str string) string {
 	src := []rune(str)
 	dest := make([]rune, 0, len(src))
-	for i := 0; i < len(src); {
-		switch src[i] {
+	for _, r := range src {
+		switch r {
 		case '\n':
 			dest = append(dest, '\\', 'n')
-			i++
 		case '\r':
-			dest = append(dest, '\\', 'n')
-			i += 2 // skip over the \n that follows the \r
+			dest = append(dest, '\\', 'r')
 		case '\t':
 			dest = append(dest, '\\', 't')
-			i++
+		case 0:
+			dest = append(dest, '\\', '0')
 		case '"':
 			dest = append(dest, '\\', '"')
-			i++
+		case '\\':
+			dest = append(dest, '\\', '\\')
 		default:
-			dest = append(dest, src[i])
-			i++
+			if r < ' ' {
+				dest = append(dest, []rune(fmt.Sprintf("\\u%04X", r))...)
+			} else {
+				dest = append(dest, r)
+			}
 		}
 	}
 	return string(dest)
 }
 
+// C# $"..." (a bflat expression in braces is compiled as any other expression; its value may be of any type)
+func compileInterpolatedString(expr InterpolatedStringForm, ns *Namespace,
+	locals map[ShortName]Type) (string, error) {
+	if !langAtLeast(ns.Options.LangVersion, 6) {
+		return "", msg(expr.Line, expr.Column, "Interpolated strings require C# 6 or later (the target is "+ns.Options.LangVersion+").")
+	}
+	braces := strings.NewReplacer("{", "{{", "}", "}}")
+	code := "$\""
+	for i, text := range expr.Texts {
+		code += braces.Replace(escapeString(text))
+		if i == len(expr.Exprs) {
+			break
+		}
+		c, t, err := compileExpression(expr.Exprs[i], ns, nil, locals)
+		if err != nil {
+			return "", err
+		}
+		if t == nil {
+			return "", msg(expr.Exprs[i].GetLine(), expr.Exprs[i].GetColumn(), "Interpolated expression has no value.")
+		}
+		// a : would otherwise start a C# format string
+		if strings.Contains(c, ":") {
+			c = "(" + c + ")"
+		}
+		code += "{" + c + "}"
+	}
+	return code + "\"", nil
+}
+
 // assumes a valid data type. Accepts Struct but not a StructDefinition
 func compileType(t Type) string {
 	switch t := t.(type) {
@@ -392,22 +551,45 @@ func compileType(t Type) string {
 		return string(t.Namespace.CSName) + "." + string(t.Name)
 	case ArrayType:
 		return compileType(t.BaseType) + "[]"
+	case NullableType:
+		if IsReferenceType(t.BaseType) {
+			return compileType(t.BaseType)
+		}
+		return compileType(t.BaseType) + "?"
 	case BuiltinType:
 		switch t.Name {
 		case "I":
 			return "int"
+		case "II":
+			return "long"
 		case "F":
 			return "float"
+		case "FF":
+			return "double"
 		case "B":
 			return "byte"
 		case "SB":
 			return "sbyte"
+		case "S":
+			return "short"
+		case "US":
+			return "ushort"
+		case "UI":
+			return "uint"
+		case "UII":
+			return "ulong"
+		case "D":
+			return "decimal"
+		case "C":
+			return "char"
 		case "Bool":
 			return "bool"
 		case "Str":
 			return "string"
 		case "Any":
 			return "object"
+		case "Type":
+			return "System.Type"
 		}
 	}
 	panic("should never reach here")
@@ -415,127 +597,322 @@ func compileType(t Type) string {
 }
 
 func compileIfForm(s IfForm, returnType Type,
-	ns *Namespace, locals map[ShortName]Type, insideLoop bool, indent string) (string, error) {
+	ns *Namespace, locals map[ShortName]Type, insideLoop bool, w *codeWriter) error {
 	c, conditionType, err := compileExpression(s.Condition, ns, BoolType, locals)
 	if err != nil {
-		return "", err
+		return err
 	}
 	if conditionType != BoolType {
-		return "", msg(s.Line, s.Column, "The 'if' condition must return a boolean.")
+		return msg(s.Line, s.Column, "The 'if' condition must return a boolean.")
 	}
-	code := "if (" + c + ") {\n"
-	c, err = compileBody(s.Body, returnType, ns, locals, insideLoop, false, indent+"\t")
+	w.open("if (" + c + ")")
+	err = compileBody(s.Body, returnType, ns, branchLocals(locals), insideLoop, w)
 	if err != nil {
-		return "", nil
+		return err
 	}
-	code += c + "\n}"
 	for i, elif := range s.ElifConds {
 		c, conditionType, err := compileExpression(elif, ns, BoolType, locals)
 		if err != nil {
-			return "", err
+			return err
 		}
 		if conditionType != BoolType {
-			return "", msg(s.Line, s.Column, "Elif condition expression does not return a boolean.")
+			return msg(s.Line, s.Column, "Elif condition expression does not return a boolean.")
 		}
-		code += " else if (" + c + ") {\n"
-		c, err = compileBody(s.ElifBodies[i], returnType, ns, locals, insideLoop, false, indent+"\t")
+		w.reopen("else if (" + c + ")")
+		err = compileBody(s.ElifBodies[i], returnType, ns, branchLocals(locals), insideLoop, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "}"
 	}
 	if len(s.ElseBody) > 0 {
-		c, err := compileBody(s.ElseBody, returnType, ns, locals, insideLoop, false, indent+"\t")
+		w.reopen("else")
+		err := compileBody(s.ElseBody, returnType, ns, branchLocals(locals), insideLoop, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += " else {\n" + c + "}"
 	}
-	return code + "\n", nil
+	w.close()
+	return nil
 }
 
-func compileBody(statements []Statement, returnType Type,
-	ns *Namespace, locals map[ShortName]Type, insideLoop bool,
-	requiresReturn bool, indent string) (string, error) {
-	code := ""
-	if requiresReturn {
-		// len(statments) will not be 0
-		if st, ok := statements[len(statements)-1].(ReturnForm); !ok {
-			return "", msg(st.Line, st.Column, "this function must end with a return statement.")
-		}
+// the variables declared in a branch are scoped to the branch
+func branchLocals(locals map[ShortName]Type) map[ShortName]Type {
+	newLocals := map[ShortName]Type{}
+	for k, v := range locals {
+		newLocals[k] = v
 	}
-	for _, s := range statements {
-		var c string
+	return newLocals
+}
+
+// (whether every path returns is checked by analyzeFlow)
+func compileBody(statements []Statement, returnType Type,
+	ns *Namespace, locals map[ShortName]Type, insideLoop bool, w *codeWriter) error {
+	for i := 0; i < len(statements); i++ {
+		line, _ := statementPosition(statements[i])
+		w.directive(lineDirective(ns, line))
+		var c string // the code of a statement written on one line
 		var err error
-		switch f := s.(type) {
+		switch f := statements[i].(type) {
 		case IfForm:
-			newLocals := map[ShortName]Type{}
-			for k, v := range locals {
-				newLocals[k] = v
-			}
-			c, err = compileIfForm(f, returnType, ns, newLocals, insideLoop, indent)
+			err = compileIfForm(f, returnType, ns, locals, insideLoop, w)
 		case AssignmentForm:
-			c, err = compileAssignment(f, ns, locals, indent)
+			c, err = compileAssignment(f, ns, locals)
+		case BlockForm:
+			err = compileBlock(f, returnType, ns, locals, insideLoop, w)
 		case ReturnForm:
-			c, err = compileReturn(f, returnType, ns, locals, indent)
+			c, err = compileReturn(f, returnType, ns, locals)
 		case BreakForm:
 			if insideLoop {
-				c += indent + "break; \n"
+				c = "break;"
 			} else {
 				err = msg(f.Line, f.Column, "cannot have break statement outside a loop.")
 			}
 		case ContinueForm:
 			if insideLoop {
-				c += indent + "continue; \n"
+				c = "continue;"
 			} else {
 				err = msg(f.Line, f.Column, "cannot have continue statement outside a loop.")
 			}
 		case CallForm:
 			c, _, err = compileCallForm(f, ns, nil, locals)
-			c = indent + c + ";\n"
+			c += ";"
 		case VarForm:
 			if locals[f.Target] != nil {
-				return "", msg(f.Line, f.Column, "Local variable of same name already exists in this scope.")
+				return msg(f.Line, f.Column, "Local variable of same name already exists in this scope.")
 			}
-			var typeStr string
 			var t Type
-			if f.Type.Name != "" {
-				t = ns.GetType(f.Type)
-				if t == nil {
-					return "", msg(f.Line, f.Column, "Var form specifies unknown type.")
-				}
-				typeStr = compileType(t) + " "
-			}
-			valStr := ""
-			var exprType Type
-			if f.Value != nil {
-				valStr, exprType, err = compileExpression(f.Value, ns, t, locals)
-				if err != nil {
-					return "", err
-				}
-				if t == nil {
-					typeStr = compileType(exprType) + " "
-				} else if !IsSubType(exprType, t) {
-					return "", msg(f.Line, f.Column, "Initial value in var statement is wrong type.")
-				}
+			var valStr string
+			t, valStr, err = compileVar(f, ns, locals)
+			if err != nil {
+				return err
 			}
 			if valStr == "" {
-				c = indent + typeStr + string(f.Target) + ";\n"
+				c = compileType(t) + " " + string(f.Target) + ";"
 			} else {
-				c = indent + typeStr + string(f.Target) + " = " + valStr + ";\n"
+				c = compileType(t) + " " + string(f.Target) + " = " + valStr + ";"
 			}
-			if t == nil {
-				locals[f.Target] = exprType
-			} else {
-				locals[f.Target] = t
+			locals[f.Target] = t
+		case TempForm:
+			var n int
+			n, err = compileTemps(statements[i:], returnType, ns, locals, insideLoop, w)
+			i += n - 1
+		}
+		if err != nil {
+			return err
+		}
+		if c != "" {
+			w.line(c)
+		}
+	}
+	return nil
+}
+
+// returns the type of a new variable and its compiled initial value ("" if none)
+func compileVar(f VarForm, ns *Namespace, locals map[ShortName]Type) (Type, string, error) {
+	var t Type
+	if f.Type.Name != "" {
+		t = ns.GetType(f.Type)
+		if t == nil {
+			return nil, "", msg(f.Line, f.Column, "Var form specifies unknown type.")
+		}
+	}
+	valStr := ""
+	if f.Value != nil {
+		var exprType Type
+		var err error
+		valStr, exprType, err = compileExpression(f.Value, ns, t, locals)
+		if err != nil {
+			return nil, "", err
+		}
+		if t == nil {
+			if exprType == NilType {
+				return nil, "", msg(f.Line, f.Column, "Var form cannot infer a type from nil (give the type, e.g. Dog?).")
+			}
+			t = exprType
+		} else if !IsSubType(exprType, t) {
+			return nil, "", msg(f.Line, f.Column, "Initial value in var statement is wrong type.")
+		}
+	}
+	ns.LocalTypes[ns.position(f.Line, f.Column, f.Target)] = t
+	return t, valStr, nil
+}
+
+// compiles a run of ast forms and the one statement following the run: the temporaries
+// exist only in that statement (and in the values of the later temporaries of the run),
+// so they are declared in a nested C# block; returns the number of statements compiled
+func compileTemps(statements []Statement, returnType Type, ns *Namespace, locals map[ShortName]Type,
+	insideLoop bool, w *codeWriter) (int, error) {
+	tempLocals := map[ShortName]Type{}
+	for k, v := range locals {
+		tempLocals[k] = v
+	}
+	var temps []TempForm
+	var lines []string // of the C# block
+	n := 0
+	for ; n < len(statements); n++ {
+		f, ok := statements[n].(TempForm)
+		if !ok {
+			break
+		}
+		// an expired temporary's C# block has closed, so its name can be reused
+		if t := tempLocals[f.Target]; t != nil {
+			if _, ok := t.(ExpiredTempType); !ok {
+				return 0, msg(f.Line, f.Column, "Local variable of same name already exists in this scope.")
 			}
 		}
+		t, valStr, err := compileVar(VarForm{f.Line, f.Column, f.Target, f.Type, f.Value}, ns, tempLocals)
 		if err != nil {
-			return "", err
+			return 0, err
+		}
+		lines = append(lines, compileType(t)+" "+string(f.Target)+" = "+valStr+";")
+		tempLocals[f.Target] = t
+		temps = append(temps, f)
+	}
+	last := temps[len(temps)-1]
+	if n == len(statements) {
+		return 0, msg(last.Line, last.Column, "Temporary variable must be followed by a statement in which it is used: "+string(last.Target))
+	}
+	switch f := statements[n].(type) {
+	case VarForm:
+		// the variable must outlive the C# block of the temporaries
+		if locals[f.Target] != nil {
+			return 0, msg(f.Line, f.Column, "Local variable of same name already exists in this scope.")
+		}
+		t, valStr, err := compileVar(f, ns, tempLocals)
+		if err != nil {
+			return 0, err
+		}
+		w.line(compileType(t) + " " + string(f.Target) + ";")
+		if valStr != "" {
+			lines = append(lines, string(f.Target)+" = "+valStr+";")
+		}
+		locals[f.Target] = t
+		w.open("")
+		for _, line := range lines {
+			w.line(line)
+		}
+	default:
+		if f, ok := f.(BlockForm); ok && len(f.Exports) > 0 {
+			return 0, msg(f.Line, f.Column, "Block form with exported variables cannot follow temporary variables.")
+		}
+		w.open("")
+		for _, line := range lines {
+			w.line(line)
+		}
+		err := compileBody(statements[n:n+1], returnType, ns, tempLocals, insideLoop, w)
+		if err != nil {
+			return 0, err
 		}
-		code += c
 	}
-	return code, nil
+	w.close()
+	for _, f := range temps {
+		locals[f.Target] = ExpiredTempType{f.Line}
+	}
+	return n + 1, nil
+}
+
+// returns the type of a local variable being read or (if isTarget) assigned;
+// a block variable whose type is not yet inferred is returned as its *PendingType
+func localType(name ShortName, t Type, isTarget bool, line int, column int) (Type, error) {
+	switch lt := t.(type) {
+	case HelperType:
+		if isTarget {
+			return nil, msg(line, column, "Cannot assign to helper function: "+string(name))
+		}
+		return nil, msg(line, column, "Helper function cannot be used as a value: "+string(name))
+	case UncapturedType:
+		return nil, msg(line, column, "Variable is not captured by the enclosing block: "+string(name))
+	case ExpiredTempType:
+		return nil, msg(line, column, "Temporary variable (assigned on line "+strconv.Itoa(lt.Line)+
+			") can only be used in the statement which follows its assignment: "+string(name))
+	case ReadOnlyType:
+		if isTarget {
+			return nil, msg(line, column, "Cannot assign to variable captured read-only by the enclosing block: "+string(name))
+		}
+		return localType(name, lt.T, isTarget, line, column)
+	case *PendingType:
+		if lt.T != nil {
+			return lt.T, nil
+		}
+		if !isTarget {
+			return nil, msg(line, column, "Block variable is read before the assignment from which its type is inferred: "+string(name))
+		}
+		return lt, nil
+	}
+	return t, nil
+}
+
+func compileBlock(f BlockForm, returnType Type, ns *Namespace, locals map[ShortName]Type,
+	insideLoop bool, w *codeWriter) error {
+	// the block sees only its captures, its exports, and the names which aren't variables
+	blockLocals := map[ShortName]Type{}
+	for name, t := range locals {
+		if _, ok := t.(HelperType); ok || name == thisWord {
+			blockLocals[name] = t
+		} else if _, ok := t.(ExpiredTempType); ok {
+			blockLocals[name] = t
+		} else {
+			blockLocals[name] = UncapturedType{}
+		}
+	}
+	for _, name := range f.ReadCaptures {
+		t := locals[name]
+		if t == nil || t == (UncapturedType{}) {
+			return msg(f.Line, f.Column, "Block captures unknown variable: "+string(name))
+		}
+		if _, ok := t.(ReadOnlyType); !ok {
+			t = ReadOnlyType{t}
+		}
+		blockLocals[name] = t
+	}
+	for _, name := range f.WriteCaptures {
+		t := locals[name]
+		if t == nil || t == (UncapturedType{}) {
+			return msg(f.Line, f.Column, "Block captures unknown variable: "+string(name))
+		}
+		if _, ok := t.(ReadOnlyType); ok {
+			return msg(f.Line, f.Column, "Block cannot capture as read/write a variable which is itself captured read-only: "+string(name))
+		}
+		blockLocals[name] = t
+	}
+	for i, name := range f.Exports {
+		if locals[name] != nil || blockLocals[name] != nil {
+			return msg(f.Line, f.Column, "Local variable of same name already exists in this scope.")
+		}
+		if f.ExportTypes[i].Name == "" {
+			blockLocals[name] = &PendingType{}
+		} else {
+			t := ns.GetType(f.ExportTypes[i])
+			if t == nil {
+				return msg(f.Line, f.Column, "Block variable has unknown type.")
+			}
+			blockLocals[name] = t
+		}
+	}
+
+	body := w.child()
+	err := compileBody(f.Body, returnType, ns, blockLocals, insideLoop, body)
+	if err != nil {
+		return err
+	}
+
+	// the exported variables are declared before the block in C#
+	for _, name := range f.Exports {
+		t := blockLocals[name]
+		if pending, ok := t.(*PendingType); ok {
+			if pending.T == nil {
+				return msg(f.Line, f.Column, "Cannot infer type of block variable (not assigned in the block): "+string(name))
+			}
+			t = pending.T
+		}
+		locals[name] = t
+		ns.LocalTypes[ns.position(f.Line, f.Column, name)] = t
+		w.line(compileType(t) + " " + string(name) + ";")
+	}
+	w.open("")
+	w.include(body)
+	w.close()
+	return nil
 }
 
 func compileIndexingForm(f IndexingForm, ns *Namespace, isTarget bool,
@@ -557,8 +934,42 @@ func compileIndexingForm(f IndexingForm, ns *Namespace, isTarget bool,
 			return
 		}
 	}
+	conditional := false // if any access is null-conditional, the value is nullable
 	for i := len(f.Args) - 2; i >= 0; i-- {
 		expr := f.Args[i]
+		access := "" // ? for a null-conditional access
+		if f.Conditional[i+1] {
+			if static {
+				err = msg(f.Line, f.Column, "Null-conditional access requires a value, not a type.")
+				return
+			}
+			if isTarget {
+				err = msg(f.Line, f.Column, "Cannot assign to a null-conditional access.")
+				return
+			}
+			if _, ok := dt.(NullableType); !ok && !IsReferenceType(dt) {
+				err = msg(f.Line, f.Column, "Null-conditional access requires a value of a nullable or reference type.")
+				return
+			}
+			if !langAtLeast(ns.Options.LangVersion, 6) {
+				err = msg(f.Line, f.Column, "Null-conditional access requires C# 6 or later (the target is "+ns.Options.LangVersion+").")
+				return
+			}
+			access = "?"
+			conditional = true
+		}
+		if _, ok := dt.(NullableType); ok && access == "" && i < len(f.Args)-2 {
+			// the value of an earlier access may be nil (the indexed value itself is left to the flow analysis,
+			// which knows where a local is not nil)
+			ns.NilAccesses[ns.position(expr.GetLine(), expr.GetColumn(), "")] = true
+		}
+		if n, ok := dt.(NullableType); ok {
+			// the members of a nullable value type's value are reached through C# Nullable<T>.Value
+			dt = n.BaseType
+			if access == "" && !IsReferenceType(dt) {
+				code += ".Value"
+			}
+		}
 		if indexedType, ok := IsIndexableType(dt); ok {
 			var c string
 			var argType Type
@@ -570,8 +981,25 @@ func compileIndexingForm(f IndexingForm, ns *Namespace, isTarget bool,
 				err = msg(f.Line, f.Column, "Expecting integer for array index in indexing form.")
 				return
 			}
-			code += "[" + c + "]"
+			code += access + "[" + c + "]"
 			dt = indexedType
+		} else if indexers := GetIndexers(dt); len(indexers) > 0 && !static && !isMemberName(expr, dt) {
+			var c string
+			var n int
+			c, dt, n, err = compileIndexerArgs(f, i, indexers, ns, isTarget, locals)
+			if err != nil {
+				return
+			}
+			// a ? on any key but the last (leftmost) would split the indexer's keys
+			// (a ? on the last is the access of the indexer's value, checked on the next pass)
+			for j := i; j > i-n+1; j-- {
+				if f.Conditional[j] {
+					err = msg(f.Line, f.Column, "Null-conditional (?) key of an indexer.")
+					return
+				}
+			}
+			code += access + c
+			i -= n - 1
 		} else {
 			if varExpr, ok := expr.(VarExpression); ok {
 				if varExpr.Namespace != "" {
@@ -587,39 +1015,135 @@ func compileIndexingForm(f IndexingForm, ns *Namespace, isTarget bool,
 					err = msg(varExpr.Line, varExpr.Column, "No field called '"+string(varExpr.Name)+"' in indexing form.")
 					return
 				}
-				code += "." + string(varExpr.Name)
+				code += access + "." + string(varExpr.Name)
+				ns.MemberNames[ns.position(varExpr.Line, varExpr.Column, varExpr.Name)] = true
 			} else {
 				err = msg(varExpr.Line, varExpr.Column, "Improper name in indexing form.")
 				return
 			}
 		}
 	}
+	if conditional {
+		dt = NullableOf(dt)
+	}
 	return code, dt, nil
 }
 
-func compileAssignment(f AssignmentForm, ns *Namespace, locals map[ShortName]Type,
-	indent string) (code string, err error) {
-	var dt Type
-	switch target := f.Target.(type) {
+// true if expr is a plain name of a field or property of t
+func isMemberName(expr Expression, t Type) bool {
+	varExpr, ok := expr.(VarExpression)
+	if !ok || varExpr.Namespace != "" {
+		return false
+	}
+	return HasFieldOrProperty(varExpr.Name, t)
+}
+
+// matches the arguments of the indexing form from index i leftwards against the indexers;
+// returns the code of the indexer access, its type, and the number of arguments consumed
+// (the keys of a multi-parameter indexer are read outwards from the indexed value, same as chained indexing)
+func compileIndexerArgs(f IndexingForm, i int, indexers []*IndexerInfo, ns *Namespace,
+	isTarget bool, locals map[ShortName]Type) (code string, dt Type, n int, err error) {
+	var matches []*IndexerInfo
+	var matchCodes [][]string
+	var firstErr error
+Loop:
+	for _, indexer := range indexers {
+		nParams := len(indexer.ParamTypes)
+		if i-nParams+1 < 0 {
+			continue
+		}
+		argCode := make([]string, nParams)
+		for j, paramType := range indexer.ParamTypes {
+			c, argType, err := compileExpression(f.Args[i-j], ns, nil, locals)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue Loop
+			}
+			if !IsSubType(argType, paramType) {
+				continue Loop
+			}
+			argCode[j] = c
+		}
+		matches = append(matches, indexer)
+		matchCodes = append(matchCodes, argCode)
+	}
+	if len(matches) == 0 {
+		if firstErr != nil {
+			return "", nil, 0, firstErr
+		}
+		return "", nil, 0, msg(f.Line, f.Column, "Indexing form arguments do not match any indexer of the indexed type.")
+	}
+	best := 0
+	if len(matches) > 1 {
+		// indexers with different numbers of params can match the same args only by consuming different numbers of them
+		paramLists := make([][]Type, len(matches))
+		for j, indexer := range matches {
+			if len(indexer.ParamTypes) != len(matches[0].ParamTypes) {
+				return "", nil, 0, msg(f.Line, f.Column, "Indexing form ambiguously matches multiple indexers.")
+			}
+			paramLists[j] = indexer.ParamTypes
+		}
+		if best = narrowestParams(paramLists); best == -1 {
+			return "", nil, 0, msg(f.Line, f.Column, "Indexing form ambiguously matches multiple indexers.")
+		}
+	}
+	match := matches[best]
+	matchCode := matchCodes[best]
+	n = len(match.ParamTypes)
+	if isTarget && i-n+1 == 0 {
+		if !match.HasSetter {
+			return "", nil, 0, msg(f.Line, f.Column, "Cannot assign to indexer with no setter.")
+		}
+	} else if !match.HasGetter {
+		return "", nil, 0, msg(f.Line, f.Column, "Cannot retrieve value of indexer with no getter.")
+	}
+	return "[" + strings.Join(matchCode, ", ") + "]", match.Type, n, nil
+}
+
+// returns the code and type of an assignment target
+// (the type of a block variable not yet inferred is its *PendingType)
+func compileTarget(target Target, line int, column int, ns *Namespace,
+	locals map[ShortName]Type) (code string, dt Type, err error) {
+	switch target := target.(type) {
 	case VarExpression:
 		if target.Namespace == "" {
 			var ok bool
 			dt, ok = locals[target.Name] // local name takes precedence over unqualified global name
 			if ok {
-				code = string(target.Name)
-				break
+				dt, err = localType(target.Name, dt, true, line, column)
+				if err != nil {
+					return "", nil, err
+				}
+				return string(target.Name), dt, nil
 			}
 		}
 		globalInfo := ns.GetGlobal(target.Name, target.Namespace)
 		if globalInfo == nil {
-			return "", msg(f.Line, f.Column, "Assignment to non-existent variable.")
+			return "", nil, msg(line, column, "Assignment to non-existent variable.")
 		}
-		code = string(globalInfo.Namespace.CSName) + "." + string(globalInfo.Name)
+		code = string(globalInfo.Namespace.CSName) + "." + GlobalsClass + "." + string(globalInfo.Name)
+		return code, globalInfo.Type, nil
 	case IndexingForm:
-		code, dt, err = compileIndexingForm(target, ns, true, locals)
-		if err != nil {
-			return "", err
-		}
+		return compileIndexingForm(target, ns, true, locals)
+	}
+	return "", nil, msg(line, column, "Invalid assignment target.")
+}
+
+func compileAssignment(f AssignmentForm, ns *Namespace, locals map[ShortName]Type) (code string, err error) {
+	code, dt, err := compileTarget(f.Target, f.Line, f.Column, ns, locals)
+	if err != nil {
+		return "", err
+	}
+	if f.Operator != "" {
+		return compileCompoundAssignment(f, code, dt, ns, locals)
+	}
+
+	// first assignment to a block variable of inferred type
+	pending, isPending := dt.(*PendingType)
+	if isPending {
+		dt = nil
 	}
 
 	code += " = "
@@ -627,14 +1151,67 @@ func compileAssignment(f AssignmentForm, ns *Namespace, locals map[ShortName]Typ
 	if err != nil {
 		return "", err
 	}
+	if isPending {
+		pending.T = exprType
+		dt = exprType
+	}
 	if !IsSubType(exprType, dt) {
 		return "", msg(f.Line, f.Column, "Assignment value is wrong type.")
 	}
-	return indent + code + exprStr + ";\n", nil
+	return code + exprStr + ";", nil
+}
+
+// e.g. (asadd x 3) is x += 3; the target is read as well as assigned
+func compileCompoundAssignment(f AssignmentForm, targetCode string, dt Type, ns *Namespace,
+	locals map[ShortName]Type) (string, error) {
+	opName := "'as" + string(f.Operator) + "'"
+	if _, ok := dt.(*PendingType); ok {
+		name := f.Target.(VarExpression).Name
+		return "", msg(f.Line, f.Column, "Block variable is read before the assignment from which its type is inferred: "+string(name))
+	}
+	if indexing, ok := f.Target.(IndexingForm); ok {
+		// check the target can be read (e.g. that a property has a getter)
+		_, _, err := compileIndexingForm(indexing, ns, false, locals)
+		if err != nil {
+			return "", err
+		}
+	}
+	valid := false
+	switch f.Operator {
+	case "add":
+		valid = IsNumber(dt) || dt == StrType
+	case "sub", "mul", "div":
+		valid = IsNumber(dt)
+	case "mod", "band", "bor", "shl", "shr", "inc", "dec":
+		valid = IsInteger(dt)
+	case "and", "or":
+		valid = dt == BoolType
+	}
+	if !valid {
+		return "", msg(f.Line, f.Column, opName+" assignment has target of wrong type.")
+	}
+	switch f.Operator {
+	case "inc":
+		return targetCode + "++;", nil
+	case "dec":
+		return targetCode + "--;", nil
+	}
+	valueType := dt
+	if f.Operator == "shl" || f.Operator == "shr" {
+		valueType = IntType // C# shift counts are int
+	}
+	exprStr, exprType, err := compileExpression(f.Value, ns, valueType, locals)
+	if err != nil {
+		return "", err
+	}
+	if !IsSubType(exprType, valueType) {
+		return "", msg(f.Line, f.Column, opName+" assignment value is wrong type.")
+	}
+	return targetCode + CompoundAssignmentSymbols[f.Operator] + exprStr + ";", nil
 }
 
-func compileReturn(f ReturnForm, returnType Type, ns *Namespace, locals map[ShortName]Type, indent string) (string, error) {
-	code := indent + "return "
+func compileReturn(f ReturnForm, returnType Type, ns *Namespace, locals map[ShortName]Type) (string, error) {
+	code := "return "
 	c, exprType, err := compileExpression(f.Value, ns, returnType, locals)
 	if err != nil {
 		return "", err
@@ -642,24 +1219,47 @@ func compileReturn(f ReturnForm, returnType Type, ns *Namespace, locals map[Shor
 	if !IsSubType(exprType, returnType) {
 		return "", msg(f.Line, f.Column, "Return value is wrong type.")
 	}
-	code += c + ";\n"
+	code += c + ";"
 	return code, nil
 }
 
-func compileFunc(f FuncDef, ns *Namespace, indent string) (string, error) {
-	code := indent + "public static "
+func compileFunc(f FuncDef, ns *Namespace, w *codeWriter) error {
+	return compileFuncOrHelper(f, string(f.Name), map[ShortName]Type{}, ns, w)
+}
+
+// a helper is compiled as a private static method named by its mangled name;
+// helperScope holds the helpers visible from the enclosing owners
+func compileFuncOrHelper(f FuncDef, mangled string, helperScope map[ShortName]Type, ns *Namespace, w *codeWriter) error {
+	isHelper := mangled != string(f.Name)
+	code := "public static "
+	if isHelper {
+		code = "private static "
+	}
 	returnType := ns.GetType(f.Return)
 	if returnType == nil {
 		code += "void "
 	} else {
 		code += compileType(returnType) + " "
 	}
-	code += string(f.Name) + "("
+	code += mangled + "("
+
+	// a function sees its own helpers and those of its owners (the nearest of the same name wins)
 	locals := map[ShortName]Type{}
+	for k, v := range helperScope {
+		locals[k] = v
+	}
+	for _, helper := range f.Helpers {
+		locals[helper.Name] = HelperType{mangled + HelperSeparator + string(helper.Name)}
+	}
+	helperScope = map[ShortName]Type{}
+	for k, v := range locals {
+		helperScope[k] = v
+	}
+
 	for i, paramName := range f.ParamNames {
 		paramType := ns.GetType(f.ParamTypes[i])
 		if paramType == nil {
-			return "", msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Function has unknown parameter type.")
+			return msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Function has unknown parameter type.")
 		}
 		locals[paramName] = paramType
 		code += string(paramName) + " " + compileType(paramType)
@@ -667,17 +1267,30 @@ func compileFunc(f FuncDef, ns *Namespace, indent string) (string, error) {
 			code += ", "
 		}
 	}
-	code += ") {\n"
-	body, err := compileBody(f.Body, returnType, ns, locals, false, returnType != nil, indent+"\t")
+	w.directive(lineDirective(ns, f.Line))
+	w.open(code + ")")
+	err := compileBody(f.Body, returnType, ns, locals, false, w)
 	if err != nil {
-		return "", err
+		return err
 	}
-	code += body + indent + "}\n"
-	return code, nil
+	err = analyzeFlow(f.ParamNames, f.ParamTypes, f.ParamPositions, []flowBody{{f.Body, returnType != nil, f.Line, f.Column}}, ns)
+	if err != nil {
+		return err
+	}
+	w.close()
+
+	for _, helper := range f.Helpers {
+		w.blankLine()
+		err := compileFuncOrHelper(helper, mangled+HelperSeparator+string(helper.Name), helperScope, ns, w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func compileMethod(f MethodDef, class Type, ns *Namespace, indent string) (string, error) {
-	code := indent + "public "
+func compileMethod(f MethodDef, class Type, ns *Namespace, w *codeWriter) error {
+	code := "public "
 	if f.IsStatic {
 		code += "static "
 	}
@@ -692,7 +1305,7 @@ func compileMethod(f MethodDef, class Type, ns *Namespace, indent string) (strin
 	for i, paramName := range f.ParamNames {
 		paramType := ns.GetType(f.ParamTypes[i])
 		if paramType == nil {
-			return "", msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Function has unknown parameter type.")
+			return msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Function has unknown parameter type.")
 		}
 		locals[paramName] = paramType
 		code += string(paramName) + " " + compileType(paramType)
@@ -700,17 +1313,22 @@ func compileMethod(f MethodDef, class Type, ns *Namespace, indent string) (strin
 			code += ", "
 		}
 	}
-	code += ") {\n"
-	body, err := compileBody(f.Body, returnType, ns, locals, false, returnType != nil, indent+"\t")
+	w.directive(lineDirective(ns, f.Line))
+	w.open(code + ")")
+	err := compileBody(f.Body, returnType, ns, locals, false, w)
 	if err != nil {
-		return "", err
+		return err
 	}
-	code += body + indent + "}\n"
-	return code, nil
+	err = analyzeFlow(f.ParamNames, f.ParamTypes, f.ParamPositions, []flowBody{{f.Body, returnType != nil, f.Line, f.Column}}, ns)
+	if err != nil {
+		return err
+	}
+	w.close()
+	return nil
 }
 
 // type should be a class or struct
-func compileConstructor(f ConstructorDef, t Type, ns *Namespace, indent string) (string, error) {
+func compileConstructor(f ConstructorDef, t Type, ns *Namespace, w *codeWriter) error {
 	var name string
 	switch t := t.(type) {
 	case *ClassInfo:
@@ -719,12 +1337,12 @@ func compileConstructor(f ConstructorDef, t Type, ns *Namespace, indent string)
 		name = string(t.Name)
 	}
 
-	code := indent + "public " + name + "("
+	code := "public " + name + "("
 	locals := map[ShortName]Type{thisWord: t}
 	for i, paramName := range f.ParamNames {
 		paramType := ns.GetType(f.ParamTypes[i])
 		if paramType == nil {
-			return "", msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Function has unknown parameter type.")
+			return msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Function has unknown parameter type.")
 		}
 		locals[paramName] = paramType
 		code += string(paramName) + " " + compileType(paramType)
@@ -732,17 +1350,22 @@ func compileConstructor(f ConstructorDef, t Type, ns *Namespace, indent string)
 			code += ", "
 		}
 	}
-	code += ") {\n"
-	body, err := compileBody(f.Body, t, ns, locals, false, false, indent+"\t")
+	w.directive(lineDirective(ns, f.Line))
+	w.open(code + ")")
+	err := compileBody(f.Body, t, ns, locals, false, w)
 	if err != nil {
-		return "", err
+		return err
 	}
-	code += body + indent + "}"
-	return code, nil
+	err = analyzeFlow(f.ParamNames, f.ParamTypes, f.ParamPositions, []flowBody{{f.Body, false, f.Line, f.Column}}, ns)
+	if err != nil {
+		return err
+	}
+	w.close()
+	return nil
 }
 
-func compileField(f FieldDef, ns *Namespace, indent string) (string, error) {
-	code := indent
+func compileField(f FieldDef, ns *Namespace, w *codeWriter) error {
+	code := ""
 	switch f.AccessLevel {
 	case PublicAccess:
 		code += "public "
@@ -757,56 +1380,93 @@ func compileField(f FieldDef, ns *Namespace, indent string) (string, error) {
 
 	t := ns.GetType(f.Type)
 	if t == nil {
-		return "", msg(f.Line, f.Column, "Field has unknown type.")
+		return msg(f.Line, f.Column, "Field has unknown type.")
 	}
 
 	typeStr := compileType(t)
 	if f.Value != nil {
 		exprStr, _, err := compileExpression(f.Value, ns, t, nil)
 		if err != nil {
-			return "", err
+			return err
 		}
 		code += typeStr + " " + string(f.Name) + " = " + exprStr + ";"
 	} else {
 		code += typeStr + " " + string(f.Name) + ";"
 	}
-	return code, nil
+	w.directive(lineDirective(ns, f.Line))
+	w.line(code)
+	return nil
 }
 
-func compileProperty(p PropertyDef, containingType Type, ns *Namespace, indent string) (string, error) {
-	var code string
+func compileProperty(p PropertyDef, containingType Type, ns *Namespace, w *codeWriter) error {
 	t := ns.GetType(p.Type)
 	if t == nil {
-		return "", msg(p.Line, p.Column, "Property has unknown type.")
+		return msg(p.Line, p.Column, "Property has unknown type.")
+	}
+
+	modifiers := ""
+	switch p.AccessLevel {
+	case PublicAccess:
+		modifiers = "public "
+	case PrivateAccess:
+		modifiers = "private "
+	case ProtectedAccess:
+		modifiers = "protected "
+	}
+	if p.IsStatic {
+		modifiers += "static "
 	}
 
+	w.directive(lineDirective(ns, p.Line))
 	if p.IsManual {
 		if len(p.GetBody) == 0 {
-			return "", msg(p.Line, p.Column, "Property is manual (no auto-backing field) but is missing explicit getter.")
+			return msg(p.Line, p.Column, "Property is manual (no auto-backing field) but is missing explicit getter.")
 		}
 
 		if len(p.SetBody) == 0 {
-			return "", msg(p.Line, p.Column, "Property is manual (no auto-backing field) but is missing explicit settter.")
+			return msg(p.Line, p.Column, "Property is manual (no auto-backing field) but is missing explicit settter.")
 		}
 	} else {
-		code += indent
-		switch p.AccessLevel {
-		case PublicAccess:
-			code += "public "
-		case PrivateAccess:
-			code += "private "
-		case ProtectedAccess:
-			code += "protected "
+		w.line(modifiers + compileType(t) + " " + string(p.Name) + "_;")
+	}
+
+	w.open(modifiers + compileType(t) + " " + string(p.Name))
+
+	if len(p.GetBody) > 0 {
+		w.open("get")
+		err := compileBody(p.GetBody, t, ns, map[ShortName]Type{thisWord: containingType}, false, w)
+		if err != nil {
+			return err
 		}
-		if p.IsStatic {
-			code += "static "
+		w.close()
+	} else {
+		w.line("get { return " + string(p.Name) + "_; }")
+	}
+
+	if len(p.SetBody) > 0 {
+		w.open("set")
+		err := compileBody(p.SetBody, nil, ns, map[ShortName]Type{thisWord: containingType, propertyValueParam: t}, false, w)
+		if err != nil {
+			return err
 		}
-		code += compileType(t) + " " + string(p.Name) + "_;\n"
+		w.close()
+	} else {
+		w.line("set { this." + string(p.Name) + "_ = " + propertyValueParam + "; }")
+	}
+	w.close()
 
+	return analyzeFlow([]ShortName{propertyValueParam}, nil, nil,
+		[]flowBody{{p.GetBody, len(p.GetBody) > 0, p.Line, p.Column}, {p.SetBody, false, p.Line, p.Column}}, ns)
+}
+
+func compileIndexer(f IndexerDef, containingType Type, ns *Namespace, w *codeWriter) error {
+	t := ns.GetType(f.Type)
+	if t == nil {
+		return msg(f.Line, f.Column, "Indexer has unknown type.")
 	}
 
-	code += indent
-	switch p.AccessLevel {
+	code := ""
+	switch f.AccessLevel {
 	case PublicAccess:
 		code += "public "
 	case PrivateAccess:
@@ -814,39 +1474,57 @@ func compileProperty(p PropertyDef, containingType Type, ns *Namespace, indent s
 	case ProtectedAccess:
 		code += "protected "
 	}
-	if p.IsStatic {
-		code += "static "
+	code += compileType(t) + " this["
+	locals := map[ShortName]Type{thisWord: containingType}
+	for i, paramName := range f.ParamNames {
+		paramType := ns.GetType(f.ParamTypes[i])
+		if paramType == nil {
+			return msg(f.ParamTypes[i].Line, f.ParamTypes[i].Column, "Indexer has unknown parameter type.")
+		}
+		locals[paramName] = paramType
+		code += compileType(paramType) + " " + string(paramName)
+		if i != len(f.ParamNames)-1 {
+			code += ", "
+		}
 	}
+	w.directive(lineDirective(ns, f.Line))
+	w.open(code + "]")
 
-	code += compileType(t) + " " + string(p.Name) + " {\n"
-
-	if len(p.GetBody) > 0 {
-		code += indent + "\tget {\n"
-		c, err := compileBody(p.GetBody, t, ns, map[ShortName]Type{thisWord: containingType}, false, true, indent+"\t\t")
+	if f.HasGetter {
+		getLocals := map[ShortName]Type{}
+		for k, v := range locals {
+			getLocals[k] = v
+		}
+		w.open("get")
+		err := compileBody(f.GetBody, t, ns, getLocals, false, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + indent + "\t}\n"
-	} else {
-		code += indent + "\tget {return " + string(p.Name) + "_;}\n"
+		w.close()
 	}
 
-	if len(p.SetBody) > 0 {
-		code += indent + "\tset {\n"
-		c, err := compileBody(p.SetBody, nil, ns, map[ShortName]Type{thisWord: containingType, propertyValueParam: t}, false, false, indent+"\t\t")
+	if f.HasSetter {
+		setLocals := map[ShortName]Type{propertyValueParam: t}
+		for k, v := range locals {
+			setLocals[k] = v
+		}
+		w.open("set")
+		err := compileBody(f.SetBody, nil, ns, setLocals, false, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + indent + "\t}\n"
-	} else {
-		code += indent + "\tset {this." + string(p.Name) + "_ = " + propertyValueParam + ";}\n"
+		w.close()
 	}
-	code += indent + "}\n"
+	w.close()
 
-	return code, nil
+	// the setter's value is implicit, so is never reported as unused
+	paramNames := append(append([]ShortName{}, f.ParamNames...), propertyValueParam)
+	return analyzeFlow(paramNames, f.ParamTypes, f.ParamPositions,
+		[]flowBody{{f.GetBody, f.HasGetter, f.Line, f.Column}, {f.SetBody, false, f.Line, f.Column}}, ns)
 }
 
-func compileClass(f ClassDef, ns *Namespace, indent string) (string, error) {
+// (the fields are written together, and a blank line before each other member)
+func compileClass(f ClassDef, ns *Namespace, w *codeWriter) error {
 	var code string
 	switch f.AccessLevel {
 	case PublicAccess:
@@ -857,7 +1535,7 @@ func compileClass(f ClassDef, ns *Namespace, indent string) (string, error) {
 		code = "protected class "
 	}
 	if f.Type.Namespace != "" {
-		return "", msg(f.Line, f.Column, "Class name in its definition should not be qualified by namespace.")
+		return msg(f.Line, f.Column, "Class name in its definition should not be qualified by namespace.")
 	}
 
 	classInfo := ns.GetClass(f.Type.Name, f.Type.Namespace)
@@ -881,58 +1559,60 @@ func compileClass(f ClassDef, ns *Namespace, indent string) (string, error) {
 			code += ", "
 		}
 	}
-	code += " {\n"
+	w.directive(lineDirective(ns, f.Line))
+	w.open(code)
 	for _, fieldDef := range f.Fields {
-		c, err := compileField(fieldDef, ns, "\t")
+		err := compileField(fieldDef, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "\n"
-	}
-	if len(f.Fields) > 0 {
-		code += "\n"
 	}
 	for _, propertyDef := range f.Properties {
-		c, err := compileProperty(propertyDef, classInfo, ns, "\t")
+		w.blankLine()
+		err := compileProperty(propertyDef, classInfo, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "\n"
 	}
-	for _, constructorDef := range f.Constructors {
-		c, err := compileConstructor(constructorDef, classInfo, ns, "\t")
+	for _, indexerDef := range f.Indexers {
+		w.blankLine()
+		err := compileIndexer(indexerDef, classInfo, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "\n"
 	}
-	for i, methodDef := range f.Methods {
-		c, err := compileMethod(methodDef, classInfo, ns, "\t")
+	for _, constructorDef := range f.Constructors {
+		w.blankLine()
+		err := compileConstructor(constructorDef, classInfo, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c
-		if i < len(f.Methods)-1 {
-			code += "\n"
+	}
+	for _, methodDef := range f.Methods {
+		w.blankLine()
+		err := compileMethod(methodDef, classInfo, ns, w)
+		if err != nil {
+			return err
 		}
 	}
-	code += "}\n\n"
-	return code, nil
+	w.close()
+	return nil
 }
 
-func compileStruct(f StructDef, ns *Namespace) (string, error) {
+// (the members are written as by compileClass)
+func compileStruct(f StructDef, ns *Namespace, w *codeWriter) error {
 	var code string
 	switch f.AccessLevel {
 	case PublicAccess:
-		code = "public "
+		code = "public struct "
 	case PrivateAccess:
-		code = "private "
+		code = "private struct "
 	case ProtectedAccess:
-		code = "protected "
+		code = "protected struct "
 	}
 
 	if f.Type.Namespace != "" {
-		return "", msg(f.Line, f.Column, "Struct name in its definition should not be qualified by namespace.")
+		return msg(f.Line, f.Column, "Struct name in its definition should not be qualified by namespace.")
 	}
 
 	structInfo := ns.GetStruct(f.Type.Name, f.Type.Namespace)
@@ -950,42 +1630,50 @@ func compileStruct(f StructDef, ns *Namespace) (string, error) {
 			code += ", "
 		}
 	}
-	code += " {"
+	w.directive(lineDirective(ns, f.Line))
+	w.open(code)
 	for _, fieldDef := range f.Fields {
-		c, err := compileField(fieldDef, ns, "\t")
+		err := compileField(fieldDef, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "\n"
 	}
 	for _, propertyDef := range f.Properties {
-		c, err := compileProperty(propertyDef, structInfo, ns, "\t")
+		w.blankLine()
+		err := compileProperty(propertyDef, structInfo, ns, w)
 		if err != nil {
-			return "", err
+			return err
+		}
+	}
+	for _, indexerDef := range f.Indexers {
+		w.blankLine()
+		err := compileIndexer(indexerDef, structInfo, ns, w)
+		if err != nil {
+			return err
 		}
-		code += c + "\n"
 	}
 	for _, constructorDef := range f.Constructors {
-		c, err := compileConstructor(constructorDef, structInfo, ns, "\t")
+		w.blankLine()
+		err := compileConstructor(constructorDef, structInfo, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "\n"
 	}
 	for _, methodDef := range f.Methods {
-		c, err := compileMethod(methodDef, structInfo, ns, "\t")
+		w.blankLine()
+		err := compileMethod(methodDef, structInfo, ns, w)
 		if err != nil {
-			return "", err
+			return err
 		}
-		code += c + "\n"
 	}
-	code += "}"
-	return code, nil
+	w.close()
+	return nil
 }
 
-func compileInterface(def InterfaceDef, ns *Namespace, indent string) (string, error) {
+// (the members are written in the order of their definition)
+func compileInterface(def InterfaceDef, ns *Namespace, w *codeWriter) error {
 	if def.Type.Namespace != "" {
-		return "", msg(def.Line, def.Column, "Interface name in its definition should not be qualified by namespace.")
+		return msg(def.Line, def.Column, "Interface name in its definition should not be qualified by namespace.")
 	}
 
 	interfaceInfo := ns.GetInterface(def.Type.Name, def.Type.Namespace)
@@ -1012,34 +1700,37 @@ func compileInterface(def InterfaceDef, ns *Namespace, indent string) (string, e
 			code += ", "
 		}
 	}
-	code += " {\n"
-	for name, methods := range interfaceInfo.Methods {
-		for _, method := range methods {
-			if method.Return == nil {
-				code += "\tvoid " + string(name) + "("
-			} else {
-				code += "\t" + compileType(method.Return) + " " + string(name) + "("
-			}
-			for i, paramType := range method.ParamTypes {
-				code += compileType(paramType)
-				if i < len(method.ParamTypes)-1 {
-					code += ", "
-				}
+	w.directive(lineDirective(ns, def.Line))
+	w.open(code)
+	// the overloads of a name are in interfaceInfo.Methods in the order of their definition
+	overloads := map[ShortName]int{}
+	for _, name := range def.MethodNames {
+		method := interfaceInfo.Methods[name][overloads[name]]
+		overloads[name]++
+		if method.Return == nil {
+			code = "void " + string(name) + "("
+		} else {
+			code = compileType(method.Return) + " " + string(name) + "("
+		}
+		for i, paramType := range method.ParamTypes {
+			code += compileType(paramType)
+			if i < len(method.ParamTypes)-1 {
+				code += ", "
 			}
-			code += ");\n"
 		}
+		w.line(code + ");")
 	}
-	for name, prop := range interfaceInfo.Properties {
-		code += "\t" + compileType(prop.Type) + " " + string(name) + " {\n"
+	for _, propertyDef := range def.Properties {
+		prop := interfaceInfo.Properties[propertyDef.Name]
+		w.open(compileType(prop.Type) + " " + string(prop.Name))
 		if prop.HasGetter {
-			code += "\t\t" + "get;\n"
+			w.line("get;")
 		}
 		if prop.HasSetter {
-			code += "\t\t" + "set;\n"
+			w.line("set;")
 		}
-		code += "\t}\n"
+		w.close()
 	}
-
-	code += "}\n\n"
-	return code, nil
+	w.close()
+	return nil
 }
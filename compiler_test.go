@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compiles the definitions as the namespace example, returning the C# and the namespace (whose Warnings are reported)
+func compileSource(t *testing.T, defs string) (string, *Namespace, error) {
+	dir := writeTree(t, map[string]string{"example.bf": "example\n\n" + defs})
+	defer os.RemoveAll(dir)
+	topDefs, err := parseFile(filepath.Join(dir, "example.bf"), true)
+	if err != nil {
+		return "", nil, err
+	}
+	ns, err := createNamespace(topDefs, "example", map[NSNameFull]*Namespace{})
+	if err != nil {
+		return "", nil, err
+	}
+	ns.Options = buildOptions{OutDir: "."}
+	files, err := codeGen(topDefs, ns, false)
+	if err != nil {
+		return "", ns, err
+	}
+	return files[0].Code, ns, nil
+}
+
+const pets = `(class Pet
+    (f name Str))
+
+(class Pets
+    (indexer Pet? : i I j I
+        (get
+            (return nil))))
+`
+
+func TestIndexingForm(t *testing.T) {
+	tests := []struct {
+		name string
+		body string // of a func taking g Pets and returning Str?
+		want string // in the C#
+		err  string
+	}{
+		{"indexer", "(return [name [2 1 g]])", "return g[1, 2].name;", ""},
+		{"null-conditional indexer value", "(return [name 2? 1 g])", "return g[1, 2]?.name;", ""},
+		{"null-conditional indexed value", "(return [name 2 1 g?])", "return g?[1, 2].name;", ""},
+		{"null-conditional between keys", "(return [name 2 1? g])", "", "Null-conditional (?) key of an indexer."},
+	}
+	for _, test := range tests {
+		code, _, err := compileSource(t, pets+"\n(func f Str? : g Pets\n    "+test.body+")\n")
+		if test.err != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !strings.Contains(code, test.want) {
+			t.Errorf("%s: expected %q in:\n%s", test.name, test.want, code)
+		}
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+// flow analysis of the bodies of a function, method, constructor, property or indexer:
+// reading a local before it is definitely assigned, unreachable statements,
+// and a path reaching the end of a body which must return a value are errors;
+// unused parameters and locals and locals which are assigned but never read are warnings,
+// as is accessing a member of a value of nullable type which may be nil
+// (run after the bodies compile, so every name in scope is known to be used correctly,
+// and the types the compiler recorded for the locals and values of the bodies are known)
+
+type flowVar struct {
+	Name     ShortName
+	Line     int
+	Column   int
+	IsParam  bool
+	Assigned bool // assigned anywhere, including by its declaration
+	Read     bool
+	Nullable bool // of nullable type as compiled, so may hold nil
+}
+
+type flowState struct {
+	Assigned  map[*flowVar]bool // the definitely assigned variables
+	MaybeNil  map[*flowVar]bool // the nullable variables which may be nil
+	Reachable bool
+	// where a reachable path last passed, reported if the path falls off the end of a body which must return
+	FallLine   int
+	FallColumn int
+	FallReason string
+}
+
+type flowAnalysis struct {
+	Vars         []*flowVar // in order of declaration
+	FinallyDepth int        // number of enclosing finally bodies
+	Namespace    *Namespace
+}
+
+type flowBody struct {
+	Statements []Statement
+	MustReturn bool // every path must return or throw
+	Line       int  // position of the definition, reported if an empty body must return
+	Column     int
+}
+
+// params beyond the length of positions (those of the params' names) are implicit (e.g. the value of a setter)
+// and so are never reported as unused; the bodies share the params
+func analyzeFlow(paramNames []ShortName, paramTypes []TypeAtom, positions []Position, bodies []flowBody, ns *Namespace) error {
+	fa := &flowAnalysis{Namespace: ns}
+	scope := map[ShortName]*flowVar{}
+	for i, name := range paramNames {
+		v := &flowVar{Name: name, IsParam: true, Assigned: true}
+		if i < len(paramTypes) {
+			_, v.Nullable = ns.GetType(paramTypes[i]).(NullableType)
+		}
+		if i < len(positions) {
+			v.Line, v.Column = positions[i].Line, positions[i].Column
+		}
+		fa.Vars = append(fa.Vars, v)
+		scope[name] = v
+	}
+	for _, body := range bodies {
+		state := flowState{
+			Assigned:   map[*flowVar]bool{},
+			MaybeNil:   map[*flowVar]bool{},
+			Reachable:  true,
+			FallLine:   body.Line,
+			FallColumn: body.Column,
+			FallReason: "the body is empty.",
+		}
+		for _, v := range scope {
+			state.Assigned[v] = true
+			state.MaybeNil[v] = v.Nullable
+		}
+		end, err := fa.body(body.Statements, scope, state)
+		if err != nil {
+			return err
+		}
+		if body.MustReturn && end.Reachable {
+			return msg(end.FallLine, end.FallColumn, "Missing return: "+end.FallReason)
+		}
+	}
+	for _, v := range fa.Vars {
+		if v.Read {
+			continue
+		}
+		if v.IsParam {
+			if v.Line != 0 {
+				warn(ns, v.Line, v.Column, "Parameter is never used: "+string(v.Name))
+			}
+		} else if v.Assigned {
+			warn(ns, v.Line, v.Column, "Local variable is assigned but never read: "+string(v.Name))
+		} else {
+			warn(ns, v.Line, v.Column, "Local variable is never used: "+string(v.Name))
+		}
+	}
+	return nil
+}
+
+func (s flowState) copy() flowState {
+	assigned := map[*flowVar]bool{}
+	for v := range s.Assigned {
+		assigned[v] = true
+	}
+	s.Assigned = assigned
+	maybeNil := map[*flowVar]bool{}
+	for v, ok := range s.MaybeNil {
+		maybeNil[v] = ok
+	}
+	s.MaybeNil = maybeNil
+	return s
+}
+
+// the state where the paths of the states join: a variable is definitely assigned
+// if assigned on every path which reaches the join, and a variable may be nil if it may be nil on any such path
+// (the first reachable path is the one reported as falling through)
+func mergeFlowStates(states []flowState) flowState {
+	var reachable []flowState
+	for _, s := range states {
+		if s.Reachable {
+			reachable = append(reachable, s)
+		}
+	}
+	if len(reachable) == 0 {
+		return flowState{Assigned: map[*flowVar]bool{}, MaybeNil: map[*flowVar]bool{}, Reachable: false}
+	}
+	merged := reachable[0].copy()
+	for _, s := range reachable[1:] {
+		for v := range merged.Assigned {
+			if !s.Assigned[v] {
+				delete(merged.Assigned, v)
+			}
+		}
+		for v, ok := range s.MaybeNil {
+			if ok {
+				merged.MaybeNil[v] = true
+			}
+		}
+	}
+	return merged
+}
+
+// the variables declared in the body are scoped to the body
+func (fa *flowAnalysis) body(statements []Statement, scope map[ShortName]*flowVar, state flowState) (flowState, error) {
+	bodyScope := map[ShortName]*flowVar{}
+	for k, v := range scope {
+		bodyScope[k] = v
+	}
+	state = state.copy()
+	for _, s := range statements {
+		if !state.Reachable {
+			line, column := statementPosition(s)
+			return state, msg(line, column, "Unreachable statement.")
+		}
+		var err error
+		state, err = fa.statement(s, bodyScope, state)
+		if err != nil {
+			return state, err
+		}
+		switch s.(type) {
+		case IfForm, SwitchForm, ForForm, TryForm, BlockForm:
+			// these forms set where their paths fall through
+		default:
+			state.FallLine, state.FallColumn = statementPosition(s)
+			state.FallReason = "the path through this statement reaches the end of the body."
+		}
+	}
+	return state, nil
+}
+
+func (fa *flowAnalysis) statement(s Statement, scope map[ShortName]*flowVar, state flowState) (flowState, error) {
+	switch f := s.(type) {
+	case VarForm:
+		return fa.declare(f.Target, f.Value, f.Line, f.Column, scope, state)
+	case TempForm:
+		// an expired temporary cannot be used, so its scope needn't end here
+		return fa.declare(f.Target, f.Value, f.Line, f.Column, scope, state)
+	case AssignmentForm:
+		if f.Value != nil {
+			err := fa.expression(f.Value, scope, state)
+			if err != nil {
+				return state, err
+			}
+		}
+		if f.Operator != "" {
+			// a compound assignment reads its target
+			err := fa.expression(f.Target.(Expression), scope, state)
+			if err != nil {
+				return state, err
+			}
+		}
+		if target, ok := f.Target.(VarExpression); ok && target.Namespace == "" {
+			if v := scope[target.Name]; v != nil {
+				v.Assigned = true
+				state.Assigned[v] = true
+				state.MaybeNil[v] = v.Nullable && f.Value != nil && fa.maybeNil(f.Value, scope, state)
+			}
+			return state, nil
+		}
+		return state, fa.expression(f.Target.(IndexingForm), scope, state)
+	case CallForm:
+		return state, fa.expression(f, scope, state)
+	case ReturnForm:
+		if fa.FinallyDepth > 0 {
+			return state, msg(f.Line, f.Column, "Cannot return from a finally clause.")
+		}
+		if f.Value != nil {
+			err := fa.expression(f.Value, scope, state)
+			if err != nil {
+				return state, err
+			}
+		}
+		state.Reachable = false
+	case ThrowForm:
+		err := fa.expression(f.Value, scope, state)
+		if err != nil {
+			return state, err
+		}
+		state.Reachable = false
+	case BreakForm, ContinueForm:
+		state.Reachable = false
+	case IfForm:
+		err := fa.expression(f.Condition, scope, state)
+		if err != nil {
+			return state, err
+		}
+		end, err := fa.body(f.Body, scope, fa.narrow(f.Condition, true, scope, state))
+		if err != nil {
+			return state, err
+		}
+		ends := []flowState{end}
+		// each later clause is reached only where the earlier conditions are false
+		state = fa.narrow(f.Condition, false, scope, state)
+		for i, cond := range f.ElifConds {
+			err = fa.expression(cond, scope, state)
+			if err != nil {
+				return state, err
+			}
+			end, err = fa.body(f.ElifBodies[i], scope, fa.narrow(cond, true, scope, state))
+			if err != nil {
+				return state, err
+			}
+			ends = append(ends, end)
+			state = fa.narrow(cond, false, scope, state)
+		}
+		if f.ElseBody != nil {
+			end, err = fa.body(f.ElseBody, scope, state)
+			if err != nil {
+				return state, err
+			}
+			ends = append(ends, end)
+		} else {
+			noElse := state.copy()
+			noElse.FallLine, noElse.FallColumn = f.Line, f.Column
+			noElse.FallReason = "the path where no condition of this if form holds reaches the end of the body (no else clause)."
+			ends = append(ends, noElse)
+		}
+		return mergeFlowStates(ends), nil
+	case SwitchForm:
+		err := fa.expression(f.Value, scope, state)
+		if err != nil {
+			return state, err
+		}
+		var ends []flowState
+		for i, val := range f.CaseValues {
+			err = fa.expression(val, scope, state)
+			if err != nil {
+				return state, err
+			}
+			end, err := fa.body(f.CaseBodies[i], scope, state)
+			if err != nil {
+				return state, err
+			}
+			ends = append(ends, end)
+		}
+		if f.DefaultBody != nil {
+			end, err := fa.body(f.DefaultBody, scope, state)
+			if err != nil {
+				return state, err
+			}
+			ends = append(ends, end)
+		} else {
+			noDefault := state.copy()
+			noDefault.FallLine, noDefault.FallColumn = f.Line, f.Column
+			noDefault.FallReason = "the path where no case of this switch form matches reaches the end of the body (no default clause)."
+			ends = append(ends, noDefault)
+		}
+		return mergeFlowStates(ends), nil
+	case ForForm:
+		err := fa.expression(f.Condition, scope, state)
+		if err != nil {
+			return state, err
+		}
+		// the body may run zero times, and break and continue end only the iteration;
+		// a later iteration may see nil assigned by an earlier one
+		state = fa.assignedMaybeNil(f.Body, scope, state)
+		_, err = fa.body(f.Body, scope, fa.narrow(f.Condition, true, scope, state))
+		if err != nil {
+			return state, err
+		}
+		if isTrueWord(f.Condition) && !breaksLoop(f.Body) {
+			// the loop never finishes, so only a return or throw leaves it
+			state.Reachable = false
+		}
+		state.FallLine, state.FallColumn = f.Line, f.Column
+		state.FallReason = "the path where this loop finishes reaches the end of the body."
+	case TryForm:
+		// the try body may stop at any statement, so the catch and finally bodies
+		// can rely only on what was assigned before the try
+		end, err := fa.body(f.Body, scope, state)
+		if err != nil {
+			return state, err
+		}
+		ends := []flowState{end}
+		state = fa.assignedMaybeNil(f.Body, scope, state)
+		for _, catchBody := range f.CatchBodies {
+			end, err = fa.body(catchBody, scope, state)
+			if err != nil {
+				return state, err
+			}
+			ends = append(ends, end)
+		}
+		merged := mergeFlowStates(ends)
+		if f.FinallyBody != nil {
+			fa.FinallyDepth++
+			for _, catchBody := range f.CatchBodies {
+				state = fa.assignedMaybeNil(catchBody, scope, state)
+			}
+			end, err = fa.body(f.FinallyBody, scope, state)
+			fa.FinallyDepth--
+			if err != nil {
+				return state, err
+			}
+			for v := range end.Assigned {
+				merged.Assigned[v] = true
+			}
+			for v, ok := range end.MaybeNil {
+				merged.MaybeNil[v] = ok
+			}
+			merged.Reachable = merged.Reachable && end.Reachable
+		}
+		return merged, nil
+	case BlockForm:
+		// the exports outlive the block; compileBlock has already restricted the body to its captures
+		for _, name := range f.Exports {
+			v := &flowVar{Name: name, Line: f.Line, Column: f.Column, Nullable: fa.isNullableLocal(name, f.Line, f.Column)}
+			fa.Vars = append(fa.Vars, v)
+			scope[name] = v
+		}
+		return fa.body(f.Body, scope, state)
+	}
+	return state, nil
+}
+
+func (fa *flowAnalysis) declare(name ShortName, value Expression, line int, column int,
+	scope map[ShortName]*flowVar, state flowState) (flowState, error) {
+	v := &flowVar{Name: name, Line: line, Column: column, Nullable: fa.isNullableLocal(name, line, column)}
+	if value != nil {
+		err := fa.expression(value, scope, state)
+		if err != nil {
+			return state, err
+		}
+		v.Assigned = true
+		state.Assigned[v] = true
+		state.MaybeNil[v] = v.Nullable && fa.maybeNil(value, scope, state)
+	}
+	fa.Vars = append(fa.Vars, v)
+	scope[name] = v
+	return state, nil
+}
+
+func (fa *flowAnalysis) expression(expr Expression, scope map[ShortName]*flowVar, state flowState) error {
+	switch e := expr.(type) {
+	case VarExpression:
+		if e.Namespace != "" {
+			return nil
+		}
+		v := scope[e.Name]
+		if v == nil {
+			return nil // not a local
+		}
+		v.Read = true
+		if !state.Assigned[v] {
+			return msg(e.Line, e.Column, "Local variable is read before it is definitely assigned: "+string(e.Name))
+		}
+	case CallForm:
+		argState := state
+		for i, arg := range e.Args {
+			if e.Namespace == "" && e.Name == "ife" && i > 0 {
+				// the operands of ife are evaluated only where the condition is true (or false)
+				argState = fa.narrow(e.Args[0], i == 1, scope, state)
+			}
+			err := fa.expression(arg, scope, argState)
+			if err != nil {
+				return err
+			}
+			if e.Namespace == "" && (e.Name == "and" || e.Name == "or") {
+				// later operands are evaluated only where the earlier are true (or false)
+				argState = fa.narrow(arg, e.Name == "and", scope, argState)
+			}
+		}
+		ns := fa.Namespace
+		if len(e.Args) > 0 && len(ns.GetMethods(e.Name, e.Namespace)) > 0 && len(ns.GetFuncs(e.Name, e.Namespace)) == 0 {
+			// the first arg of a method call is its receiver
+			fa.checkDereference(e.Args[0], scope, state)
+		}
+	case TypeCallForm:
+		for _, arg := range e.Args {
+			err := fa.expression(arg, scope, state)
+			if err != nil {
+				return err
+			}
+		}
+	case InterpolatedStringForm:
+		for _, arg := range e.Exprs {
+			err := fa.expression(arg, scope, state)
+			if err != nil {
+				return err
+			}
+		}
+	case IndexingForm:
+		last := len(e.Args) - 1
+		for i, arg := range e.Args {
+			// a plain name other than the indexed value may be a field or property rather than a local
+			if varExpr, ok := arg.(VarExpression); ok && i != last && fa.isMemberName(varExpr) {
+				continue
+			}
+			err := fa.expression(arg, scope, state)
+			if err != nil {
+				return err
+			}
+		}
+		if !e.Conditional[last] {
+			fa.checkDereference(e.Args[last], scope, state)
+		}
+		ns := fa.Namespace
+		for _, arg := range e.Args[:last] {
+			if ns.NilAccesses[ns.position(arg.GetLine(), arg.GetColumn(), "")] {
+				warn(ns, arg.GetLine(), arg.GetColumn(), "Possibly nil value is dereferenced.")
+			}
+		}
+	}
+	return nil
+}
+
+// true if the name compiled as a field or property in an indexing form
+func (fa *flowAnalysis) isMemberName(varExpr VarExpression) bool {
+	ns := fa.Namespace
+	return ns.MemberNames[ns.position(varExpr.Line, varExpr.Column, varExpr.Name)]
+}
+
+// true if the compiler declared the local of a nullable type
+func (fa *flowAnalysis) isNullableLocal(name ShortName, line int, column int) bool {
+	ns := fa.Namespace
+	_, ok := ns.LocalTypes[ns.position(line, column, name)].(NullableType)
+	return ok
+}
+
+// true if the compiler found the value of the call or indexing form at the position to be of a nullable type
+func (fa *flowAnalysis) isNullableValue(line int, column int) bool {
+	ns := fa.Namespace
+	_, ok := ns.ValueTypes[ns.position(line, column, "")].(NullableType)
+	return ok
+}
+
+// warn if the value of the expression may be nil
+func (fa *flowAnalysis) checkDereference(expr Expression, scope map[ShortName]*flowVar, state flowState) {
+	if !fa.maybeNil(expr, scope, state) {
+		return
+	}
+	if varExpr, ok := expr.(VarExpression); ok {
+		warn(fa.Namespace, varExpr.Line, varExpr.Column, "Possibly nil value is dereferenced: "+string(varExpr.Name))
+	} else {
+		warn(fa.Namespace, expr.GetLine(), expr.GetColumn(), "Possibly nil value is dereferenced.")
+	}
+}
+
+// the local named by the expression if the expression is a nullable local, otherwise nil
+func nullableLocal(expr Expression, scope map[ShortName]*flowVar) *flowVar {
+	varExpr, ok := expr.(VarExpression)
+	if !ok || varExpr.Namespace != "" {
+		return nil
+	}
+	if v := scope[varExpr.Name]; v != nil && v.Nullable {
+		return v
+	}
+	return nil
+}
+
+func isNilWord(expr Expression) bool {
+	varExpr, ok := expr.(VarExpression)
+	return ok && varExpr.Namespace == "" && varExpr.Name == nilWord
+}
+
+func isTrueWord(expr Expression) bool {
+	varExpr, ok := expr.(VarExpression)
+	return ok && varExpr.Namespace == "" && varExpr.Name == trueWord
+}
+
+// true if a break in the statements ends the loop whose body they are (rather than a loop nested in it);
+// a break in a switch case is counted too, though it may end only the switch
+func breaksLoop(statements []Statement) bool {
+	for _, s := range statements {
+		switch f := s.(type) {
+		case BreakForm:
+			return true
+		case IfForm:
+			if breaksLoop(f.Body) || breaksLoop(f.ElseBody) {
+				return true
+			}
+			for _, body := range f.ElifBodies {
+				if breaksLoop(body) {
+					return true
+				}
+			}
+		case SwitchForm:
+			if breaksLoop(f.DefaultBody) {
+				return true
+			}
+			for _, body := range f.CaseBodies {
+				if breaksLoop(body) {
+					return true
+				}
+			}
+		case TryForm:
+			if breaksLoop(f.Body) || breaksLoop(f.FinallyBody) {
+				return true
+			}
+			for _, body := range f.CatchBodies {
+				if breaksLoop(body) {
+					return true
+				}
+			}
+		case BlockForm:
+			if breaksLoop(f.Body) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// true if the value of the expression may be nil: a value of nullable type may be nil
+// unless it is a local which the state knows to be not nil
+func (fa *flowAnalysis) maybeNil(expr Expression, scope map[ShortName]*flowVar, state flowState) bool {
+	switch e := expr.(type) {
+	case VarExpression:
+		if isNilWord(e) {
+			return true
+		}
+		if v := nullableLocal(e, scope); v != nil {
+			return state.MaybeNil[v]
+		}
+	case IndexingForm:
+		return fa.isNullableValue(e.Line, e.Column)
+	case CallForm:
+		if e.Namespace == "" {
+			// the value of these is one of their operands, which may be locals known to be not nil
+			switch {
+			case e.Name == "coalesce" && len(e.Args) > 0:
+				return fa.maybeNil(e.Args[len(e.Args)-1], scope, state)
+			case e.Name == "ife" && len(e.Args) == 3:
+				return fa.maybeNil(e.Args[1], scope, fa.narrow(e.Args[0], true, scope, state)) ||
+					fa.maybeNil(e.Args[2], scope, fa.narrow(e.Args[0], false, scope, state))
+			}
+		}
+		return fa.isNullableValue(e.Line, e.Column)
+	}
+	return false
+}
+
+// the state where the condition is known to be true (or false): a nullable local compared to nil,
+// or tested with istype, is known to be nil or not
+func (fa *flowAnalysis) narrow(cond Expression, isTrue bool, scope map[ShortName]*flowVar, state flowState) flowState {
+	op, ok := cond.(CallForm)
+	if !ok || op.Namespace != "" {
+		return state
+	}
+	switch op.Name {
+	case "not":
+		if len(op.Args) == 1 {
+			return fa.narrow(op.Args[0], !isTrue, scope, state)
+		}
+	case "and", "or":
+		// every operand of a true and (or a false or) has the same truth
+		if (op.Name == "and") == isTrue {
+			for _, arg := range op.Args {
+				state = fa.narrow(arg, isTrue, scope, state)
+			}
+		}
+	case "eq", "neq":
+		if len(op.Args) != 2 {
+			break
+		}
+		a, b := op.Args[0], op.Args[1]
+		if isNilWord(a) {
+			a, b = b, a
+		}
+		if v := nullableLocal(a, scope); v != nil && isNilWord(b) {
+			state = state.copy()
+			state.MaybeNil[v] = (op.Name == "eq") == isTrue
+		}
+	case "istype":
+		if len(op.Args) != 1 || !isTrue {
+			break
+		}
+		if v := nullableLocal(op.Args[0], scope); v != nil {
+			state = state.copy()
+			state.MaybeNil[v] = false
+		}
+	}
+	return state
+}
+
+// the state where the nullable locals assigned anywhere in the statements may be nil
+func (fa *flowAnalysis) assignedMaybeNil(statements []Statement, scope map[ShortName]*flowVar, state flowState) flowState {
+	state = state.copy()
+	var visit func(statements []Statement)
+	visit = func(statements []Statement) {
+		for _, s := range statements {
+			switch f := s.(type) {
+			case AssignmentForm:
+				if target, ok := f.Target.(VarExpression); ok && target.Namespace == "" {
+					if v := scope[target.Name]; v != nil && v.Nullable {
+						state.MaybeNil[v] = true
+					}
+				}
+			case IfForm:
+				visit(f.Body)
+				for _, body := range f.ElifBodies {
+					visit(body)
+				}
+				visit(f.ElseBody)
+			case SwitchForm:
+				for _, body := range f.CaseBodies {
+					visit(body)
+				}
+				visit(f.DefaultBody)
+			case ForForm:
+				visit(f.Body)
+			case TryForm:
+				visit(f.Body)
+				for _, body := range f.CatchBodies {
+					visit(body)
+				}
+				visit(f.FinallyBody)
+			case BlockForm:
+				visit(f.Body)
+			}
+		}
+	}
+	visit(statements)
+	return state
+}
+
+func statementPosition(s Statement) (line int, column int) {
+	switch f := s.(type) {
+	case CallForm:
+		return f.Line, f.Column
+	case AssignmentForm:
+		return f.Line, f.Column
+	case IfForm:
+		return f.Line, f.Column
+	case SwitchForm:
+		return f.Line, f.Column
+	case VarForm:
+		return f.Line, f.Column
+	case ReturnForm:
+		return f.Line, f.Column
+	case ForForm:
+		return f.Line, f.Column
+	case TryForm:
+		return f.Line, f.Column
+	case ThrowForm:
+		return f.Line, f.Column
+	case ContinueForm:
+		return f.Line, f.Column
+	case BreakForm:
+		return f.Line, f.Column
+	case BlockForm:
+		return f.Line, f.Column
+	case TempForm:
+		return f.Line, f.Column
+	}
+	return 0, 0
+}
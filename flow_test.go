@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the warnings of compiling the definitions which contain the text
+func warningsOf(t *testing.T, defs string, text string) []string {
+	_, ns, err := compileSource(t, defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := []string{}
+	for _, w := range ns.Warnings {
+		if strings.Contains(w.Error(), text) {
+			warnings = append(warnings, w.Error())
+		}
+	}
+	return warnings
+}
+
+const owners = `(class Pet
+    (f name Str)
+    (f owner Pet?))
+
+(func find Pet?
+    (return nil))
+`
+
+func TestNilDereference(t *testing.T) {
+	tests := []struct {
+		name string
+		body string // of a func taking a Pet and returning Str?
+		want []string
+	}{
+		{"nullable member", "(return [name owner a])",
+			[]string{"Line 11, column 14: Warning: Possibly nil value is dereferenced."}},
+		{"nullable member of nullable member", "(return [name owner owner a])",
+			[]string{"Line 11, column 14: Warning: Possibly nil value is dereferenced.",
+				"Line 11, column 19: Warning: Possibly nil value is dereferenced."}},
+		{"null-conditional member", "(return [name owner? a])", []string{}},
+		{"nullable call", "(return [name (find)])",
+			[]string{"Line 11, column 19: Warning: Possibly nil value is dereferenced."}},
+		{"nullable local", "(var p (find))\n    (return [name p])",
+			[]string{"Line 12, column 19: Warning: Possibly nil value is dereferenced: p"}},
+		{"tested local", "(var p (find))\n    (if (eq p nil)\n        (return nil))\n    (return [name p])", []string{}},
+	}
+	for _, test := range tests {
+		got := warningsOf(t, owners+"\n(func f Str? : a Pet\n    "+test.body+")\n", "dereferenced")
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnusedParameters(t *testing.T) {
+	tests := []struct {
+		name string
+		defs string
+		want []string
+	}{
+		{"array and plain types", "(func f I : a A<I> b I\n    (return 0))", []string{
+			"Line 3, column 13: Warning: Parameter is never used: a",
+			"Line 3, column 20: Warning: Parameter is never used: b",
+		}},
+		{"one used", "(func f I : a A<I> b I\n    (return [0 a]))", []string{
+			"Line 3, column 20: Warning: Parameter is never used: b",
+		}},
+		{"method", "(class Dog\n    (m bark I : times I? sounds A<Str>\n        (return 0)))", []string{
+			"Line 4, column 17: Warning: Parameter is never used: times",
+			"Line 4, column 26: Warning: Parameter is never used: sounds",
+		}},
+	}
+	for _, test := range tests {
+		got := warningsOf(t, test.defs+"\n", "Parameter")
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
@@ -1,17 +1,66 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// the characters which may follow a backslash in a char literal (besides u and four hex digits)
+const charEscapes = `ntr0\'"`
+
+// the characters which may follow a backslash in a string literal (besides u and four hex digits, or U and eight):
+// \n newline, \t tab, \r carriage return, \0 null, \\ backslash, \` backtick, and \' and \" (as in C#)
+const stringEscapes = "ntr0\\`'\""
 
 // returns true if rune is a letter of the English alphabet
 func isAlpha(r rune) bool {
 	return (r >= 65 && r <= 90) || (r >= 97 && r <= 122)
 }
 
+// returns true if rune can begin a word: a letter of the English alphabet, or a letter of any other script
+func isWordStart(r rune) bool {
+	return isAlpha(r) || (r >= 128 && unicode.IsLetter(r))
+}
+
+// returns true if rune can continue a word (as in C#, this includes the combining marks and digits of any script)
+func isWordPart(r rune) bool {
+	return isWordStart(r) || r == '_' || isNumeral(r) ||
+		(r >= 128 && unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc))
+}
+
+// true if the word begins with an uppercase (or titlecase) letter, as a type name must;
+// a word beginning with a letter of a script without case (e.g. Chinese) is a name
+func isCapitalized(word string) bool {
+	r, _ := utf8.DecodeRuneInString(word)
+	return unicode.IsUpper(r) || unicode.IsTitle(r)
+}
+
+// the width of the runes in UTF-16 code units, the unit of a column (as editors count them)
+func columnWidth(runes []rune) int {
+	width := 0
+	for _, r := range runes {
+		width += utf16.RuneLen(r)
+	}
+	return width
+}
+
 // returns true if rune is a numeral
 func isNumeral(r rune) bool {
 	return (r >= 48 && r <= 57)
 }
 
+func isHexNumeral(r rune) bool {
+	return isNumeral(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+func isBinaryNumeral(r rune) bool {
+	return r == '0' || r == '1'
+}
+
 func isSigil(r rune) bool {
 	for _, s := range sigils {
 		if r == s {
@@ -21,6 +70,235 @@ func isSigil(r rune) bool {
 	return false
 }
 
+// true if the next token starts a new atom (so a - followed by a numeral is a negative number literal)
+func startsAtom(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	switch tokens[len(tokens)-1].Type {
+	case Spaces, Newline, OpenParen, OpenSquare, OpenCurly, OpenAngle:
+		return true
+	}
+	return false
+}
+
+// lexes the number literal at runes[i] (a numeral, or - then a numeral), returning its value and length in runes:
+// decimal with an optional fractional part and exponent (e.g. 1.5e-3), hex (0xFF) or binary (0b1010),
+// with optional _ digit separators and an optional suffix naming its number type, e.g. 255B or 1.5F
+// (a hex literal's digits are read first, so its suffix cannot begin with a hex digit)
+func lexNumber(runes []rune, i int) (NumberValue, int, error) {
+	start := i
+	value := NumberValue{Radix: 10}
+	if runes[i] == '-' {
+		value.Negative = true
+		i++
+	}
+	isDigit := isNumeral
+	if runes[i] == '0' && i+1 < len(runes) {
+		switch runes[i+1] {
+		case 'x', 'X':
+			value.Radix = 16
+			isDigit = isHexNumeral
+			i += 2
+		case 'b', 'B':
+			// b is a prefix only if a binary digit follows (perhaps after separators): 0B is the byte zero
+			j := i + 2
+			for j < len(runes) && runes[j] == '_' {
+				j++
+			}
+			if j < len(runes) && isBinaryNumeral(runes[j]) {
+				value.Radix = 2
+				isDigit = isBinaryNumeral
+				i += 2
+			}
+		}
+	}
+	// a run of digits with any separators removed; a separator may not end the run
+	digits := func() (string, error) {
+		first := i
+		for i < len(runes) && (isDigit(runes[i]) || runes[i] == '_') {
+			i++
+		}
+		run := string(runes[first:i])
+		if run == "" || strings.HasSuffix(run, "_") {
+			return "", errors.New("Invalid number literal (expecting a digit)")
+		}
+		return strings.Replace(run, "_", "", -1), nil
+	}
+	integerDigits, err := digits()
+	if err != nil {
+		return value, 0, err
+	}
+	floatText := integerDigits
+	if value.Radix == 10 && i+1 < len(runes) && runes[i] == '.' && isNumeral(runes[i+1]) {
+		i++
+		fractionalDigits, err := digits()
+		if err != nil {
+			return value, 0, err
+		}
+		floatText += "." + fractionalDigits
+		value.Fractional = true
+	}
+	if value.Radix == 10 && i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+		i++
+		floatText += "e"
+		if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+			floatText += string(runes[i])
+			i++
+		}
+		exponentDigits, err := digits()
+		if err != nil {
+			return value, 0, errors.New("Invalid number literal (expecting exponent digits)")
+		}
+		floatText += exponentDigits
+		value.Fractional = true
+	}
+	suffixStart := i
+	for i < len(runes) && isAlpha(runes[i]) {
+		i++
+	}
+	value.Suffix = ShortName(runes[suffixStart:i])
+	if value.Suffix != "" && numberTypeByName(value.Suffix) == nil {
+		return value, 0, errors.New("Invalid number literal suffix " + string(value.Suffix) + " (expecting a number type)")
+	}
+	if i < len(runes) && (isNumeral(runes[i]) || runes[i] == '_') {
+		return value, 0, errors.New("Invalid number literal (unexpected digit)")
+	}
+	if value.Fractional {
+		// too great for 64 bits gives an infinite value (and the error is then ignored)
+		value.Float, _ = strconv.ParseFloat(floatText, 64)
+		if value.Negative {
+			value.Float = -value.Float
+		}
+	} else {
+		var err error
+		value.Magnitude, err = strconv.ParseUint(integerDigits, value.Radix, 64)
+		value.Overflow = err != nil
+	}
+	// C# before 7 has neither digit separators nor binary literals
+	switch {
+	case value.Radix == 16:
+		value.Text = "0x" + integerDigits
+	case value.Radix == 2 && !value.Overflow:
+		value.Text = "0x" + strings.ToUpper(strconv.FormatUint(value.Magnitude, 16))
+	case value.Radix == 2:
+		value.Text = "0b" + integerDigits // (out of range for every type, so never compiled)
+	default:
+		value.Text = floatText
+	}
+	if value.Negative {
+		value.Text = "-" + value.Text
+	}
+	return value, i - start, nil
+}
+
+// the length of the escape sequence at runes[i] (a backslash): one of the simple escapes,
+// or u and four hex digits, or (if long is true) U and eight hex digits
+func lexEscape(runes []rune, i int, simple string, long bool) (int, error) {
+	if i+1 < len(runes) && strings.ContainsRune(simple, runes[i+1]) {
+		return 2, nil
+	}
+	nDigits := 0
+	if i+1 < len(runes) && runes[i+1] == 'u' {
+		nDigits = 4
+	} else if long && i+1 < len(runes) && runes[i+1] == 'U' {
+		nDigits = 8
+	} else {
+		return 0, errors.New("Invalid escape sequence")
+	}
+	if i+2+nDigits > len(runes) {
+		return 0, errors.New("Invalid unicode escape")
+	}
+	for _, r := range runes[i+2 : i+2+nDigits] {
+		if !isHexNumeral(r) {
+			return 0, errors.New("Invalid unicode escape")
+		}
+	}
+	if val, _ := strconv.ParseUint(string(runes[i+2:i+2+nDigits]), 16, 32); val > unicode.MaxRune {
+		return 0, errors.New("Invalid unicode escape (beyond the greatest code point)")
+	}
+	return 2 + nDigits, nil
+}
+
+// lexes the string literal at runes[i], returning its length in runes: a backtick string with escape sequences,
+// a $ interpolated string, e.g. $`{count} left`, in which each {} holds a bflat expression (which may itself contain strings)
+// and {{ and }} are literal braces, or a @ raw string, in which a backslash is just a backslash
+func lexString(runes []rune, i int) (int, error) {
+	start := i
+	raw := runes[i] == '@'
+	interpolated := runes[i] == '$'
+	if raw || interpolated {
+		i++
+	}
+	i++ // the opening backtick
+
+	depth := 0 // of the braces enclosing an expression of an interpolated string
+	for {
+		if i >= len(runes) {
+			return 0, errors.New("String literal not closed by end of file")
+		}
+		r := runes[i]
+		switch {
+		case depth > 0 && (r == '`' || ((r == '$' || r == '@') && i+1 < len(runes) && runes[i+1] == '`')):
+			n, err := lexString(runes, i)
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		case depth > 0 && r == '\'':
+			// a char such as '{' or '`' in an expression neither opens nor closes anything
+			n, err := lexChar(runes, i)
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		case r == '\\' && !raw && depth == 0:
+			n, err := lexEscape(runes, i, stringEscapes, true)
+			if err != nil {
+				return 0, errors.New(err.Error() + " in string literal")
+			}
+			i += n
+		case r == '`':
+			return i + 1 - start, nil
+		case interpolated && depth == 0 && (r == '{' || r == '}') && i+1 < len(runes) && runes[i+1] == r:
+			i += 2
+		case interpolated && r == '{':
+			depth++
+			i++
+		case interpolated && r == '}':
+			if depth == 0 {
+				return 0, errors.New("Unmatched } in interpolated string (write }} for a brace)")
+			}
+			depth--
+			i++
+		default:
+			i++
+		}
+	}
+}
+
+// lexes the char literal at runes[i] (a quote), returning its length in runes:
+// one character or one escape sequence, written the same as in C#
+func lexChar(runes []rune, i int) (int, error) {
+	end := i + 1
+	if end < len(runes) && runes[end] == '\\' {
+		n, err := lexEscape(runes, end, charEscapes, false)
+		if err != nil {
+			return 0, errors.New(err.Error() + " in char literal")
+		}
+		end += n
+	} else if end < len(runes) && runes[end] != '\'' && runes[end] != '\n' && runes[end] != '\r' {
+		if runes[end] > 0xFFFF {
+			return 0, errors.New("Char literal is outside the range of C (which is UTF-16)")
+		}
+		end++
+	}
+	if end == i+1 || end >= len(runes) || runes[end] != '\'' {
+		return 0, errors.New("Char literal must be one character or escape sequence")
+	}
+	return end + 1 - i, nil
+}
+
 func lex(code string) ([]Token, error) {
 	tokens := []Token{}
 	runes := []rune(code)
@@ -28,11 +306,13 @@ func lex(code string) ([]Token, error) {
 	column := 1
 	for i := 0; i < len(runes); {
 		r := runes[i]
-		if r >= 128 {
-			return nil, errors.New("File improperly contains a non-ASCII character at line " + itoa(line) + " and column " + itoa(column))
+		// other than in strings, chars and comments (which are consumed whole), only words may contain non-ASCII
+		if r >= 128 && !isWordStart(r) {
+			return nil, errors.New("File improperly contains a non-ASCII character outside a string, char, comment or name at line " +
+				itoa(line) + " and column " + itoa(column))
 		}
 		if r == '\n' {
-			tokens = append(tokens, Token{Newline, "\n", line, column})
+			tokens = append(tokens, Token{Newline, "\n", line, column, nil})
 			line++
 			column = 1
 			i++
@@ -40,7 +320,7 @@ func lex(code string) ([]Token, error) {
 			if runes[i+1] != '\n' {
 				return nil, errors.New("File improperly contains a CR not followed by a LF at end of line " + itoa(line))
 			}
-			tokens = append(tokens, Token{Newline, "\n", line, column})
+			tokens = append(tokens, Token{Newline, "\n", line, column, nil})
 			line++
 			column = 1
 			i += 2
@@ -50,39 +330,39 @@ func lex(code string) ([]Token, error) {
 				i++
 			}
 			i++
-			tokens = append(tokens, Token{Newline, "\n", line, column})
+			tokens = append(tokens, Token{Newline, "\n", line, column, nil})
 			line++
 			column = 1
 		} else if r == '(' {
-			tokens = append(tokens, Token{OpenParen, "(", line, column})
+			tokens = append(tokens, Token{OpenParen, "(", line, column, nil})
 			column++
 			i++
 		} else if r == ')' {
-			tokens = append(tokens, Token{CloseParen, ")", line, column})
+			tokens = append(tokens, Token{CloseParen, ")", line, column, nil})
 			column++
 			i++
 		} else if r == '[' {
-			tokens = append(tokens, Token{OpenSquare, "[", line, column})
+			tokens = append(tokens, Token{OpenSquare, "[", line, column, nil})
 			column++
 			i++
 		} else if r == ']' {
-			tokens = append(tokens, Token{CloseSquare, "]", line, column})
+			tokens = append(tokens, Token{CloseSquare, "]", line, column, nil})
 			column++
 			i++
 		} else if r == '{' {
-			tokens = append(tokens, Token{OpenCurly, "{", line, column})
+			tokens = append(tokens, Token{OpenCurly, "{", line, column, nil})
 			column++
 			i++
 		} else if r == '}' {
-			tokens = append(tokens, Token{CloseCurly, "}", line, column})
+			tokens = append(tokens, Token{CloseCurly, "}", line, column, nil})
 			column++
 			i++
 		} else if r == '<' {
-			tokens = append(tokens, Token{OpenAngle, "<", line, column})
+			tokens = append(tokens, Token{OpenAngle, "<", line, column, nil})
 			column++
 			i++
 		} else if r == '>' {
-			tokens = append(tokens, Token{CloseAngle, ">", line, column})
+			tokens = append(tokens, Token{CloseAngle, ">", line, column, nil})
 			column++
 			i++
 		} else if r == ' ' {
@@ -92,59 +372,54 @@ func lex(code string) ([]Token, error) {
 				i++
 			}
 			content := string(runes[firstIdx:i])
-			tokens = append(tokens, Token{Spaces, content, line, column})
+			tokens = append(tokens, Token{Spaces, content, line, column, nil})
 		} else if r == '\t' {
 			return nil, errors.New("File improperly contains a tab character: line " + itoa(line) + " and column " + itoa(column))
-		} else if r == '`' { // start of a string
-			prev := r
-			endIdx := i + 1
-			endColumn := column
-			endLine := line
-			for {
-				if endIdx >= len(runes) {
-					return nil, errors.New("String literal not closed by end of file on line " + itoa(line) + " and column " + itoa(column))
-				}
-				current := runes[endIdx]
-				if current == '\n' {
-					endLine++
-					endColumn = 1
+		} else if r == '`' || ((r == '$' || r == '@') && i+1 < len(runes) && runes[i+1] == '`') { // start of a string
+			n, err := lexString(runes, i)
+			if err != nil {
+				return nil, errors.New(err.Error() + " at line " + itoa(line) + " and column " + itoa(column))
+			}
+			content := runes[i : i+n]
+			tokens = append(tokens, Token{StringLiteral, string(content), line, column, nil})
+			for _, c := range content {
+				if c == '\n' {
+					line++
+					column = 1
 				} else {
-					endColumn++
+					column += utf16.RuneLen(c)
 				}
-				if current == '`' && prev != '\\' { // end of the string
-					endIdx++
-					break
-				}
-				prev = current
-				endIdx++
 			}
-			tokens = append(tokens, Token{StringLiteral, string(runes[i:endIdx]), line, column})
-			column = endColumn
-			line = endLine
-			i = endIdx
-		} else if isNumeral(r) { // start of a number
-			endIdx := i + 1
-			for isNumeral(runes[endIdx]) {
-				endIdx++
+			i += n
+		} else if isNumeral(r) || (r == '-' && i+1 < len(runes) && isNumeral(runes[i+1]) && startsAtom(tokens)) { // start of a number
+			value, n, err := lexNumber(runes, i)
+			if err != nil {
+				return nil, errors.New(err.Error() + " at line " + itoa(line) + " and column " + itoa(column))
 			}
-			tokens = append(tokens, Token{NumberLiteral, string(runes[i:endIdx]), line, column})
-			column += (endIdx - i)
-			i = endIdx
-		} else if isAlpha(r) { // start of a word
+			tokens = append(tokens, Token{NumberLiteral, string(runes[i : i+n]), line, column, &value})
+			column += n
+			i += n
+		} else if r == '\'' { // start of a char
+			n, err := lexChar(runes, i)
+			if err != nil {
+				return nil, errors.New(err.Error() + " at line " + itoa(line) + " and column " + itoa(column))
+			}
+			tokens = append(tokens, Token{CharLiteral, string(runes[i : i+n]), line, column, nil})
+			column += n
+			i += n
+		} else if isWordStart(r) { // start of a word
 			endIdx := i + 1
-			r := runes[endIdx]
-			for isAlpha(r) || r == '_' || isNumeral(r) {
+			for endIdx < len(runes) && isWordPart(runes[endIdx]) {
 				endIdx++
-				r = runes[endIdx]
 			}
 
 			content := string(runes[i:endIdx])
 
-			tokens = append(tokens, Token{Word, content, line, column})
-			column += (endIdx - i)
+			tokens = append(tokens, Token{Word, content, line, column, nil})
+			column += columnWidth(runes[i:endIdx])
 			i = endIdx
 		} else if isSigil(r) {
-			tokens = append(tokens, Token{Sigil, string(r), line, column})
+			tokens = append(tokens, Token{Sigil, string(r), line, column, nil})
 			column++
 			i++
 		} else {
@@ -195,11 +470,14 @@ Loop2:
 			elements = append(elements, SigilAtom{t.Content, t.Line, t.Column})
 			i++
 		case NumberLiteral:
-			elements = append(elements, NumberAtom{t.Content, t.Line, t.Column})
+			elements = append(elements, NumberAtom{t.Content, *t.Number, t.Line, t.Column})
 			i++
 		case StringLiteral:
 			elements = append(elements, StringAtom{t.Content, t.Line, t.Column})
 			i++
+		case CharLiteral:
+			elements = append(elements, CharAtom{t.Content, t.Line, t.Column})
+			i++
 		case Spaces, Newline:
 			i++
 			break Loop2
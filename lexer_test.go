@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestLexNumber(t *testing.T) {
+	tests := []struct {
+		literal string
+		text    string // the C# of the value
+		suffix  ShortName
+		length  int // in runes, if not the whole literal
+		err     string
+	}{
+		{literal: "0B", text: "0", suffix: "B"},
+		{literal: "0B)", text: "0", suffix: "B", length: 2},
+		{literal: "0b101", text: "0x5"},
+		{literal: "0B1111_0000", text: "0xF0"},
+		{literal: "0b_1B", text: "0x1", suffix: "B"},
+		{literal: "0x_FF", text: "0xFF"},
+		{literal: "-1_000.5e_3", text: "-1000.5e3"},
+		{literal: "0B_", err: "Invalid number literal (unexpected digit)"},
+		{literal: "0b2", err: "Invalid number literal suffix b (expecting a number type)"},
+		{literal: "1_", err: "Invalid number literal (expecting a digit)"},
+	}
+	for _, test := range tests {
+		runes := []rune(test.literal)
+		value, n, err := lexNumber(runes, 0)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, expected %q", test.literal, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.literal, err)
+			continue
+		}
+		length := test.length
+		if length == 0 {
+			length = len(runes)
+		}
+		if value.Text != test.text || value.Suffix != test.suffix || n != length {
+			t.Errorf("%s: got %s with suffix %q and length %d, expected %s with suffix %q and length %d",
+				test.literal, value.Text, value.Suffix, n, test.text, test.suffix, length)
+		}
+	}
+}
+
+func TestLexString(t *testing.T) {
+	tests := []struct {
+		literal string
+		length  int // in runes, if not the whole literal
+		err     string
+	}{
+		{literal: "$`a {(cat x '{')} b`"},
+		{literal: "$`a {(cat x '}')} b`"},
+		{literal: "$`a {(cat x '`')} b` c", length: 20},
+		{literal: "$`a {(cat x '\\'' '{')} b`"},
+		{literal: "$`a {(cat `}` '{')} b`"},
+		{literal: "$`a {(cat x '{{')} b`", err: "Char literal must be one character or escape sequence"},
+	}
+	for _, test := range tests {
+		runes := []rune(test.literal)
+		n, err := lexString(runes, 0)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, expected %q", test.literal, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.literal, err)
+			continue
+		}
+		length := test.length
+		if length == 0 {
+			length = len(runes)
+		}
+		if n != length {
+			t.Errorf("%s: got length %d, expected %d", test.literal, n, length)
+		}
+	}
+}
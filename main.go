@@ -1,24 +1,44 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 type Token struct {
 	Type    TokenType
-	Content string // the token itself, e.g. a number 3.7 is stored here as "3.7"
-	Line    int    // first line is line 1
-	Column  int    // first character of a line is in column 1
+	Content string       // the token itself, e.g. a number 3.7 is stored here as "3.7"
+	Line    int          // first line is line 1
+	Column  int          // first character of a line is in column 1
+	Number  *NumberValue // the parsed value of a NumberLiteral (nil for other tokens)
+}
+
+// a number literal as parsed by the lexer, e.g. -0xFF, 1_000, 1.5e-3 or 255B
+type NumberValue struct {
+	Text       string    // the literal without its suffix or digit separators, and a binary literal in hex
+	Radix      int       // 2, 10 or 16
+	Negative   bool      // true if written with a leading -
+	Magnitude  uint64    // the absolute value of an integer literal
+	Overflow   bool      // true if the magnitude of an integer literal doesn't fit in 64 bits
+	Fractional bool      // true if the literal has a fractional part or an exponent
+	Float      float64   // the value of a fractional literal (infinite if too great for FF)
+	Suffix     ShortName // the number type written after the literal, e.g. B in 255B ("" if none)
 }
 
 const thisWord = "me"
+const nilWord = "nil"
+const trueWord = "true"
+const falseWord = "false"
 const propertyValueParam = "value"
 const IndentSpaces = 4
 const directoryPrefix = "bf."
@@ -81,6 +101,7 @@ var operatorWords = []string{
 	"typeof", // get Type
 	"sizeof",
 	"default",
+	"coalesce", // ?? operator
 	// assignment operators
 	"as",
 	"asadd",
@@ -118,8 +139,8 @@ var sigils = []rune{
 	'\\',
 	':',
 	';',
-	'\'',
 	'"',
+	'?',
 }
 
 var debug = fmt.Println // alias for debug printing
@@ -143,6 +164,7 @@ const (
 	CloseAngle
 	NumberLiteral
 	StringLiteral
+	CharLiteral
 	Sigil
 )
 
@@ -153,6 +175,7 @@ type Statement interface {
 type GlobalDef struct {
 	Line        int
 	Column      int
+	File        string // the source file declaring it
 	Name        ShortName
 	Type        TypeAtom
 	Value       Expression
@@ -177,20 +200,30 @@ type NamespaceDef struct {
 	Annotations []AnnotationForm
 }
 
+// of a name in the source
+type Position struct {
+	Line   int
+	Column int
+}
+
 type FuncDef struct {
-	Line        int
-	Column      int
-	Name        ShortName
-	ParamTypes  []TypeAtom
-	ParamNames  []ShortName
-	Return      TypeAtom
-	Body        []Statement
-	Annotations []AnnotationForm
+	Line           int
+	Column         int
+	File           string // the source file declaring it
+	Name           ShortName
+	ParamTypes     []TypeAtom
+	ParamNames     []ShortName
+	ParamPositions []Position // of the names
+	Return         TypeAtom
+	Body           []Statement
+	Annotations    []AnnotationForm
+	Helpers        []FuncDef // helper functions private to this function
 }
 
 type ClassDef struct {
 	Line         int
 	Column       int
+	File         string // the source file declaring it
 	Type         TypeAtom
 	AccessLevel  AccessLevel
 	Supertypes   []TypeAtom
@@ -198,6 +231,7 @@ type ClassDef struct {
 	Methods      []MethodDef
 	Constructors []ConstructorDef
 	Properties   []PropertyDef
+	Indexers     []IndexerDef
 	Annotations  []AnnotationForm
 }
 
@@ -207,6 +241,7 @@ type ClassInfo struct {
 	Parent     *ClassInfo
 	Fields     map[ShortName]FieldInfo
 	Properties map[ShortName]PropertyInfo
+	Indexers   []*IndexerInfo
 	Methods    map[ShortName][]*CallableInfo
 	Interfaces []*InterfaceInfo
 	Params     []Type
@@ -217,6 +252,7 @@ type StructInfo struct {
 	Namespace  *Namespace
 	Fields     map[ShortName]FieldInfo
 	Properties map[ShortName]PropertyInfo
+	Indexers   []*IndexerInfo
 	Methods    map[ShortName][]*CallableInfo
 	Interfaces []*InterfaceInfo
 	Params     []Type
@@ -250,19 +286,59 @@ type ArrayType struct {
 	BaseType Type
 }
 
-func (t *ClassInfo) Type()     {}
-func (t *StructInfo) Type()    {}
-func (t *InterfaceInfo) Type() {}
-func (t ArrayType) Type()      {}
-func (t BuiltinType) Type()    {}
+// T? (C# T? for a value type, or just T for a reference type, which C# allows to be null anyway)
+type NullableType struct {
+	BaseType Type
+}
+
+// type of a helper function name in the locals of its owner
+// (helpers are callable but are not values)
+type HelperType struct {
+	Mangled string // key into Namespace.Helpers
+}
+
+// types only found in the locals of a block form body (see compileBlock)
+
+// exported variable of a block whose type is inferred from its first assignment
+// (a pointer so that the inference is seen by all copies of the locals)
+type PendingType struct {
+	T Type // nil until inferred
+}
+
+// outer variable captured by a block which can be read but not assigned
+type ReadOnlyType struct {
+	T Type
+}
+
+// outer variable not captured by a block
+type UncapturedType struct{}
+
+// temporary variable whose scope has ended (see compileTemps)
+type ExpiredTempType struct {
+	Line int // line of the ast form which assigned the temporary
+}
+
+func (t *ClassInfo) Type()      {}
+func (t *StructInfo) Type()     {}
+func (t *InterfaceInfo) Type()  {}
+func (t ArrayType) Type()       {}
+func (t NullableType) Type()    {}
+func (t BuiltinType) Type()     {}
+func (t HelperType) Type()      {}
+func (t *PendingType) Type()    {}
+func (t ReadOnlyType) Type()    {}
+func (t UncapturedType) Type()  {}
+func (t ExpiredTempType) Type() {}
 
 type CallableInfo struct {
 	IsMethod   bool
+	Name       ShortName // name in its own namespace (an import may alias it)
 	Namespace  *Namespace
 	ParamNames []ShortName
 	ParamTypes []Type
 	Return     Type
-	Static     Type // class or struct to which this method belongs
+	Static     Type   // class or struct to which this method belongs
+	Mangled    string // C# name of a helper function
 }
 
 type Expression interface {
@@ -272,9 +348,18 @@ type Expression interface {
 }
 
 type IndexingForm struct {
+	Line        int
+	Column      int
+	Args        []Expression
+	Conditional []bool // true where the value of an arg was written with a trailing ?, e.g. [name owner?], so is accessed with ?.
+}
+
+// $`...` with a bflat expression in each {}: Texts are the text around the expressions (one more than Exprs)
+type InterpolatedStringForm struct {
 	Line   int
 	Column int
-	Args   []Expression
+	Texts  []string // with escape sequences already resolved
+	Exprs  []Expression
 }
 
 type CallForm struct {
@@ -301,13 +386,15 @@ type VarExpression struct {
 	Namespace NSNameShort
 }
 
-func (a VarExpression) Expression()    {}
-func (a ParsedNumberAtom) Expression() {}
-func (a StringAtom) Expression()       {}
-func (a IndexingForm) Expression()     {}
-func (a CallForm) Expression()         {}
-func (a TypeCallForm) Expression()     {}
-func (a TypeAtom) Expression()         {}
+func (a VarExpression) Expression()          {}
+func (a ParsedNumberAtom) Expression()       {}
+func (a StringAtom) Expression()             {}
+func (a CharAtom) Expression()               {}
+func (a IndexingForm) Expression()           {}
+func (a CallForm) Expression()               {}
+func (a InterpolatedStringForm) Expression() {}
+func (a TypeCallForm) Expression()           {}
+func (a TypeAtom) Expression()               {}
 
 func (a TypeAtom) GetLine() int {
 	return a.Line
@@ -330,6 +417,13 @@ func (a CallForm) GetColumn() int {
 	return a.Column
 }
 
+func (a InterpolatedStringForm) GetLine() int {
+	return a.Line
+}
+func (a InterpolatedStringForm) GetColumn() int {
+	return a.Column
+}
+
 func (a TypeCallForm) GetLine() int {
 	return a.Line
 }
@@ -344,6 +438,13 @@ func (a StringAtom) GetColumn() int {
 	return a.Column
 }
 
+func (a CharAtom) GetLine() int {
+	return a.Line
+}
+func (a CharAtom) GetColumn() int {
+	return a.Column
+}
+
 func (a ParsedNumberAtom) GetLine() int {
 	return a.Line
 }
@@ -425,6 +526,8 @@ func (a TryForm) Statement()        {}
 func (a ThrowForm) Statement()      {}
 func (a ContinueForm) Statement()   {}
 func (a BreakForm) Statement()      {}
+func (a BlockForm) Statement()      {}
+func (a TempForm) Statement()       {}
 
 type IfForm struct {
 	Line       int
@@ -460,6 +563,7 @@ type TypeAtom struct {
 	Name      ShortName
 	Namespace NSNameShort
 	Params    []TypeAtom
+	Nullable  bool // written with a trailing ?, e.g. I? or Dog?
 }
 
 type Target interface {
@@ -467,10 +571,11 @@ type Target interface {
 }
 
 type AssignmentForm struct {
-	Line   int
-	Column int
-	Target Target
-	Value  Expression
+	Line     int
+	Column   int
+	Operator ShortName // operator of a compound assignment, e.g. "add" for asadd; empty for as
+	Target   Target
+	Value    Expression // nil for asinc and asdec
 }
 
 func (a VarExpression) Target() {}
@@ -507,6 +612,16 @@ type ForForm struct {
 	Body      []Statement
 }
 
+type BlockForm struct {
+	Line          int
+	Column        int
+	Exports       []ShortName // new variables which exist beyond the block
+	ExportTypes   []TypeAtom  // parallel to Exports; zero TypeAtom where the type is inferred
+	ReadCaptures  []ShortName // outer variables the block can read
+	WriteCaptures []ShortName // outer variables the block can read and assign
+	Body          []Statement
+}
+
 type VarForm struct {
 	Line   int
 	Column int
@@ -515,6 +630,15 @@ type VarForm struct {
 	Value  Expression
 }
 
+// (ast name value): a temporary usable only in the statement which follows
+type TempForm struct {
+	Line   int
+	Column int
+	Target ShortName
+	Type   TypeAtom
+	Value  Expression
+}
+
 type AnnotationForm struct {
 	Line      int
 	Column    int
@@ -545,6 +669,7 @@ type FieldInfo struct {
 type StructDef struct {
 	Line         int
 	Column       int
+	File         string // the source file declaring it
 	Type         TypeAtom
 	AccessLevel  AccessLevel
 	Interfaces   []TypeAtom
@@ -552,12 +677,14 @@ type StructDef struct {
 	Methods      []MethodDef
 	Constructors []ConstructorDef
 	Properties   []PropertyDef
+	Indexers     []IndexerDef
 	Annotations  []AnnotationForm
 }
 
 type InterfaceDef struct {
 	Line              int
 	Column            int
+	File              string // the source file declaring it
 	Type              TypeAtom
 	AccessLevel       AccessLevel
 	ParentInterfaces  []TypeAtom
@@ -570,24 +697,26 @@ type InterfaceDef struct {
 }
 
 type MethodDef struct {
-	Line        int
-	Column      int
-	Name        ShortName
-	ParamTypes  []TypeAtom
-	ParamNames  []ShortName
-	IsStatic    bool
-	Return      TypeAtom
-	Body        []Statement
-	Annotations []AnnotationForm
+	Line           int
+	Column         int
+	Name           ShortName
+	ParamTypes     []TypeAtom
+	ParamNames     []ShortName
+	ParamPositions []Position // of the names
+	IsStatic       bool
+	Return         TypeAtom
+	Body           []Statement
+	Annotations    []AnnotationForm
 }
 
 type ConstructorDef struct {
-	Line        int
-	Column      int
-	ParamTypes  []TypeAtom
-	ParamNames  []ShortName
-	Body        []Statement
-	Annotations []AnnotationForm
+	Line           int
+	Column         int
+	ParamTypes     []TypeAtom
+	ParamNames     []ShortName
+	ParamPositions []Position // of the names
+	Body           []Statement
+	Annotations    []AnnotationForm
 }
 
 type PropertyDef struct {
@@ -614,6 +743,29 @@ type PropertyInfo struct {
 	Static      Type
 }
 
+type IndexerDef struct {
+	Line           int
+	Column         int
+	Type           TypeAtom
+	ParamTypes     []TypeAtom
+	ParamNames     []ShortName
+	ParamPositions []Position // of the names
+	GetBody        []Statement
+	SetBody        []Statement
+	HasGetter      bool
+	HasSetter      bool
+	Annotations    []AnnotationForm
+	AccessLevel    AccessLevel
+}
+
+type IndexerInfo struct {
+	Type       Type
+	ParamNames []ShortName
+	ParamTypes []Type
+	HasGetter  bool
+	HasSetter  bool
+}
+
 type Atom interface {
 	Atom()
 	GetLine() int
@@ -666,15 +818,15 @@ type Symbol struct {
 
 type NumberAtom struct {
 	Content string
+	Value   NumberValue
 	Line    int
 	Column  int
 }
 
 type ParsedNumberAtom struct {
-	IntegerPart    string
-	FractionalPart string
-	Line           int
-	Column         int
+	Value  NumberValue
+	Line   int
+	Column int
 }
 
 type StringAtom struct {
@@ -683,6 +835,12 @@ type StringAtom struct {
 	Column  int
 }
 
+type CharAtom struct {
+	Content string // includes enclosing quote marks (and any escape is the same as in C#)
+	Line    int
+	Column  int
+}
+
 type SigilAtom struct {
 	Content string
 	Line    int
@@ -697,6 +855,7 @@ func (a AtomChain) Atom()  {}
 func (a Symbol) Atom()     {}
 func (a NumberAtom) Atom() {}
 func (a StringAtom) Atom() {}
+func (a CharAtom) Atom()   {}
 func (a SigilAtom) Atom()  {}
 
 type TopDefs struct {
@@ -710,6 +869,7 @@ type TopDefs struct {
 
 const GlobalsClass = "_Globals"
 const FuncsClass = "_Funcs"
+const HelperSeparator = "__"
 
 type NSNameFull string
 type NSNameShort string // for namespace names with dots, the part after the last dot (otherwise same as NSNameFull)
@@ -729,6 +889,28 @@ type Namespace struct {
 	Globals      map[ShortName]*GlobalInfo
 	Funcs        map[ShortName][]*CallableInfo
 	Methods      map[ShortName][]*CallableInfo
+	Helpers      map[string][]*CallableInfo // keyed by mangled name: owner names and helper name joined by "__"
+	Warnings     []error                    // reported after compilation, which they do not stop
+	APIHash      string                     // hash of the declarations (see hashAPI)
+	Options      buildOptions
+	SourceFile   string                  // of the definition being compiled, named by the #line directives
+	MemberNames  map[sourcePosition]bool // the plain names in indexing forms which compiled as fields or properties
+	LocalTypes   map[sourcePosition]Type // the type of each local, keyed by its declaration
+	ValueTypes   map[sourcePosition]Type // the type of each call and indexing form (keyed with no name)
+	NilAccesses  map[sourcePosition]bool // the keys of indexing forms which access a value of nullable type without ?
+}
+
+// a name at a position in the source file of the definition being compiled: what the compiler
+// learns of a body is recorded by position for the flow analysis of the body which follows
+type sourcePosition struct {
+	File   string
+	Line   int
+	Column int
+	Name   ShortName
+}
+
+func (ns *Namespace) position(line int, column int, name ShortName) sourcePosition {
+	return sourcePosition{ns.SourceFile, line, column, name}
 }
 
 type TypeInfo interface {
@@ -777,6 +959,76 @@ var SignedByteType = BuiltinType{
 	Name: "SB",
 }
 
+var ShortType = BuiltinType{
+	Name: "S",
+}
+
+var UnsignedShortType = BuiltinType{
+	Name: "US",
+}
+
+var UnsignedIntType = BuiltinType{
+	Name: "UI",
+}
+
+var UnsignedLongType = BuiltinType{
+	Name: "UII",
+}
+
+var DecimalType = BuiltinType{
+	Name: "D",
+}
+
+// not a number type, but converts implicitly to the number types wide enough for any char
+var CharType = BuiltinType{
+	Name: "C",
+}
+
+// the implicit (widening) conversions between number types (and from C), same as C#
+var numberWidenings = map[ShortName][]ShortName{
+	"SB":  {"S", "I", "II", "F", "FF", "D"},
+	"B":   {"S", "US", "I", "UI", "II", "UII", "F", "FF", "D"},
+	"S":   {"I", "II", "F", "FF", "D"},
+	"US":  {"I", "UI", "II", "UII", "F", "FF", "D"},
+	"I":   {"II", "F", "FF", "D"},
+	"UI":  {"II", "UII", "F", "FF", "D"},
+	"II":  {"F", "FF", "D"},
+	"UII": {"F", "FF", "D"},
+	"F":   {"FF"},
+	"C":   {"US", "I", "UI", "II", "UII", "F", "FF", "D"},
+}
+
+type integerRange struct {
+	Min int64
+	Max uint64
+}
+
+// the least and greatest values of each integer type
+var integerRanges = map[ShortName]integerRange{
+	"SB":  {math.MinInt8, math.MaxInt8},
+	"B":   {0, math.MaxUint8},
+	"S":   {math.MinInt16, math.MaxInt16},
+	"US":  {0, math.MaxUint16},
+	"I":   {math.MinInt32, math.MaxInt32},
+	"UI":  {0, math.MaxUint32},
+	"II":  {math.MinInt64, math.MaxInt64},
+	"UII": {0, math.MaxUint64},
+}
+
+// the number types from narrowest to widest (the order in which the common type of numbers is sought)
+var numberTypes = []Type{SignedByteType, ByteType, ShortType, UnsignedShortType, IntType, UnsignedIntType,
+	LongType, UnsignedLongType, FloatType, DoubleType, DecimalType}
+
+// the type of nil, which is a value of every nullable type
+var NilType = BuiltinType{
+	Name: "nil",
+}
+
+// System.Type, the type of a typeof operation
+var TypeType = BuiltinType{
+	Name: "Type",
+}
+
 var OperatorSymbols = map[ShortName]string{
 	"add":  " + ",
 	"sub":  " - ",
@@ -788,6 +1040,9 @@ var OperatorSymbols = map[ShortName]string{
 	"band": " & ",
 	"bor":  " | ",
 	"bxor": " ^ ",
+	"xor":  " ^ ",
+	"shl":  " << ",
+	"shr":  " >> ",
 	"cat":  " + ",
 	"eq":   " == ",
 	"neq":  " != ",
@@ -797,59 +1052,203 @@ var OperatorSymbols = map[ShortName]string{
 	"lte":  " <= ",
 }
 
+// operators of compound assignments
+// (the and/or assignments evaluate their operand even when the target already decides the result)
+var CompoundAssignmentSymbols = map[ShortName]string{
+	"add":  " += ",
+	"sub":  " -= ",
+	"mul":  " *= ",
+	"div":  " /= ",
+	"mod":  " %= ",
+	"shl":  " <<= ",
+	"shr":  " >>= ",
+	"band": " &= ",
+	"bor":  " |= ",
+	"and":  " &= ",
+	"or":   " |= ",
+}
+
+// how the generated C# is written (see also the manifest)
+type buildOptions struct {
+	OutDir           string              // directory of the generated files
+	Split            bool                // one file per class, struct or interface (plus one for the globals and funcs)
+	LangVersion      string              // the target C# version, e.g. 7.3 (empty for the latest)
+	Defines          []string            // preprocessor symbols defined at the top of every generated file
+	WarningsAsErrors bool                // a namespace with any warnings fails to compile
+	Stubs            map[NSNameFull]bool // namespaces which declare external C# code, so have none generated
+}
+
 func main() {
 	debugMode := true
-	var directory string
-	var namespace NSNameFull
+	var directories []string
+	var roots []NSNameFull
+	m, err := readManifest(manifestFilename)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	if m == nil {
+		m = &manifest{Options: buildOptions{OutDir: "."}}
+	}
+	// the program arguments override the manifest
+	options, args, err := parseOptions(os.Args[1:], m.Options)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	// bflat map-error translates the positions in generated C# of the errors or stack traces it reads
+	// (from the files given, or else the standard input) to positions in the source
+	if len(args) > 0 && args[0] == "map-error" {
+		err = mapErrorFiles(args[1:], options.OutDir)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		return
+	}
+	// bflat watch recompiles whenever a source file changes
+	watching := len(args) > 0 && args[0] == "watch"
+	if watching {
+		args = args[1:]
+	}
+	directories = m.Sources
+	if len(directories) == 0 {
+		directories = []string{"."}
+	}
+	roots = m.Namespaces
 	if debugMode {
-		directory = "."
-		namespace = "something.test"
+		if len(roots) == 0 {
+			roots = []NSNameFull{"something.test"}
+		}
 	} else {
-		if len(os.Args) < 2 {
-			fmt.Println("Must specify a namespace (short name) and directory.")
-			return
+		if len(args) < 1 && len(roots) == 0 {
+			exitWithError("Must specify a namespace (short name) and directory, or the namespaces in " + manifestFilename + ".")
+		}
+		if len(args) > 0 {
+			roots = []NSNameFull{NSNameFull(args[0])} // expecting the full namespace name
 		}
-		namespace = NSNameFull(os.Args[1]) // expecting the full namespace name
 
-		directory = "."
-		if len(os.Args) == 3 {
-			directory = os.Args[2]
+		if len(args) == 2 {
+			directories = []string{args[1]}
 		}
 
-		if len(os.Args) > 3 {
-			fmt.Println("Too many program arguments. Expecting 2 program arguments at most.")
-			return
+		if len(args) > 2 {
+			exitWithError("Too many program arguments. Expecting 2 program arguments at most.")
 		}
 	}
 
-	start := time.Now()
-
-	nsFileLookup := map[NSNameFull][]string{}
-	err := buildNamespaceFileLookup(directory, nsFileLookup)
-	if err != nil {
-		fmt.Println(errors.New("Cannot find or read files of namespace: " + string(namespace) + err.Error()))
+	if watching {
+		watch(roots, directories, m.Stubs, options)
 		return
 	}
-	err = compileNamespace(namespace, nsFileLookup, map[NSNameFull]*Namespace{})
+
+	start := time.Now()
+	err = build(roots, directories, m.Stubs, options)
 	if err != nil {
-		fmt.Println(err)
-		return
+		exitWithError(err.Error())
 	}
-
 	debug("Time: ", time.Since(start))
 }
 
+// reports the error on the standard error and exits with a failing status (so a CI or MSBuild step fails)
+func exitWithError(s string) {
+	fmt.Fprintln(os.Stderr, s)
+	os.Exit(1)
+}
+
+// finds the source and stub files in the directories, compiles the roots, and prints the warnings
+// (a namespace whose source and imported APIs are unchanged since the last build is not compiled again)
+func build(roots []NSNameFull, directories []string, stubDirectories []string, options buildOptions) error {
+	nsFileLookup := map[NSNameFull][]string{}
+	for _, directory := range directories {
+		err := buildNamespaceFileLookup(directory, nsFileLookup)
+		if err != nil {
+			return errors.New("Cannot find or read source files in " + directory + ": " + err.Error())
+		}
+	}
+	// the stubs are found like other source files, but no code is generated for them
+	options.Stubs = map[NSNameFull]bool{}
+	for _, directory := range stubDirectories {
+		stubLookup := map[NSNameFull][]string{}
+		err := buildNamespaceFileLookup(directory, stubLookup)
+		if err != nil {
+			return errors.New("Cannot find or read stub files in " + directory + ": " + err.Error())
+		}
+		for name, files := range stubLookup {
+			if len(nsFileLookup[name]) != 0 {
+				return errors.New("Found both source files and stub files for namespace: " + string(name))
+			}
+			nsFileLookup[name] = files
+			options.Stubs[name] = true
+		}
+	}
+	namespaces := map[NSNameFull]*Namespace{}
+	var err error
+	for _, namespace := range roots {
+		err = compileNamespace(namespace, nsFileLookup, namespaces, options)
+		if err != nil {
+			break
+		}
+	}
+	printWarnings(namespaces)
+	return err
+}
+
+// separates the options (--out dir and --split) from the other program arguments
+// and applies them to the options of the manifest
+func parseOptions(args []string, options buildOptions) (buildOptions, []string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--out":
+			if i+1 == len(args) {
+				return options, nil, errors.New("Expecting a directory after --out.")
+			}
+			i++
+			options.OutDir = args[i]
+		case "--split":
+			options.Split = true
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return options, nil, errors.New("Unknown option: " + args[i])
+			}
+			rest = append(rest, args[i])
+		}
+	}
+	return options, rest, nil
+}
+
 func msg(line int, column int, s string) error {
 	return errors.New("Line " + strconv.Itoa(line) + ", column " +
 		strconv.Itoa(column) + ": " + s)
 }
 
+// records a warning in the namespace; unlike an error, compilation continues
+func warn(ns *Namespace, line int, column int, s string) {
+	ns.Warnings = append(ns.Warnings, msg(line, column, "Warning: "+s))
+}
+
+// prints the warnings of the namespaces in order of namespace name
+func printWarnings(namespaces map[NSNameFull]*Namespace) {
+	names := []string{}
+	for name := range namespaces {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, w := range namespaces[NSNameFull(name)].Warnings {
+			fmt.Println(name + ": " + w.Error())
+		}
+	}
+}
+
+// the byte order mark with which some editors begin a UTF-8 file
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 // namespace is expected on first line with no leading whitespace
 func fileReadNamespace(file string) (NSNameFull, error) {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return "", err
 	}
+	data = bytes.TrimPrefix(data, utf8BOM)
 	data = append(data, '\n', '\n')
 	firstNewline := 0
 	for i := 0; i < len(data); i++ {
@@ -869,90 +1268,110 @@ func fileReadNamespace(file string) (NSNameFull, error) {
 	return NSNameFull(str), nil
 }
 
+// true if the name is components separated by dots, each a lowercase letter followed by letters and digits
 func isFullNamespace(ns string) bool {
-	data := []byte(ns)
-	componentStart := true
-	for i := 0; i < len(data); i++ {
-		if data[i] == '.' {
-			componentStart = false
-			continue
+	for _, component := range strings.Split(ns, ".") {
+		if component == "" {
+			return false
 		}
-		if componentStart {
-			// is not lowercase alpha
-			if data[i] < 'a' && data[i] > 'z' {
+		for i, r := range component {
+			if i == 0 && !unicode.IsLower(r) || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
 				return false
 			}
-			componentStart = false
-		} else {
-			if data[i] == ' ' || data[i] == '\r' || data[i] == '\t' || data[i] == '\n' {
-				return true
-			}
-			if data[i] >= 'a' && data[i] <= 'z' {
-				continue
-			}
-			if data[i] >= 'A' && data[i] <= 'Z' {
-				continue
-			}
-			if data[i] >= '0' && data[i] <= '9' {
-				continue
-			}
-			if data[i] == '.' {
-				componentStart = true
-				continue
-			}
 		}
 	}
 	return true
 }
 
+// finds the source files in dir and, recursively, in its subdirectories whose names begin with bf.
+// (the main file of a namespace is <short name>.bf, and its other files, in the same directory,
+// are <anything>_<short name>.bf); every bad file is reported, not just the first
 func buildNamespaceFileLookup(dir string, nsFileLookup map[NSNameFull][]string) error {
+	var problems []string
+	findSourceFiles(dir, nsFileLookup, &problems)
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func findSourceFiles(dir string, nsFileLookup map[NSNameFull][]string, problems *[]string) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		log.Fatal(err)
+		*problems = append(*problems, err.Error())
+		return
+	}
+	report := func(path string, s string) {
+		*problems = append(*problems, path+": "+s)
+	}
+	isSource := func(file os.FileInfo) bool {
+		name := file.Name()
+		return !file.IsDir() && strings.HasSuffix(name, fileSuffix) && len(name) > len(fileSuffix) && !strings.HasPrefix(name, ".")
 	}
+
+	// the main files
 	nsNames := map[NSNameShort]NSNameFull{}
+	badMains := map[string]bool{} // the other files of a bad main file are not reported too
 	for _, file := range files {
 		name := file.Name()
-		if !file.IsDir() && strings.Index(name, "_") == -1 && strings.HasSuffix(name, fileSuffix) {
-			if len(name) == len(fileSuffix) {
-				continue
-			}
-			nsName, err := fileReadNamespace(name)
-			if err != nil {
-				return err
-			}
-			shortName := getNSNameShort(nsName)
-			if name[:len(name)-len(fileSuffix)] != string(shortName) {
-				return errors.New("Source file has wrong name for its declared namespace: " + name)
-			}
-			if len(nsFileLookup[nsName]) != 0 {
-				return errors.New("Found more than one set of source files for namespace: " + string(nsName))
-			}
-			nsFileLookup[nsName] = append(nsFileLookup[nsName], dir+"/"+name)
-			nsNames[shortName] = nsName
+		path := filepath.Join(dir, name)
+		if !isSource(file) || strings.Contains(name, "_") {
+			continue
+		}
+		nsName, err := fileReadNamespace(path)
+		if err != nil {
+			report(path, err.Error())
+			badMains[strings.TrimSuffix(name, fileSuffix)] = true
+			continue
+		}
+		shortName := getNSNameShort(nsName)
+		if strings.TrimSuffix(name, fileSuffix) != string(shortName) {
+			report(path, "Source file has wrong name for its declared namespace "+string(nsName)+" (expecting "+string(shortName)+fileSuffix+").")
+			badMains[strings.TrimSuffix(name, fileSuffix)] = true
+			continue
+		}
+		if len(nsFileLookup[nsName]) != 0 {
+			report(path, "Found more than one set of source files for namespace "+string(nsName)+" (also "+nsFileLookup[nsName][0]+").")
+			badMains[strings.TrimSuffix(name, fileSuffix)] = true
+			continue
 		}
+		nsFileLookup[nsName] = []string{path}
+		nsNames[shortName] = nsName
 	}
 
+	// the other files of the namespaces, which follow the main file
 	for _, file := range files {
 		name := file.Name()
-		idx := strings.Index(name, "_")
-		if !file.IsDir() && idx != -1 && strings.HasSuffix(name, fileSuffix) {
-			if nsName, ok := nsNames[NSNameShort(name[idx:])]; ok {
-				nsFileLookup[nsName] = append(nsFileLookup[nsName], dir+"/"+name)
-			} else {
-				return errors.New("Source file has no main source file of matching name: " + name)
-			}
+		path := filepath.Join(dir, name)
+		idx := strings.LastIndex(name, "_")
+		if !isSource(file) || idx == -1 {
+			continue
+		}
+		mainName := name[idx+1 : len(name)-len(fileSuffix)]
+		nsName, ok := nsNames[NSNameShort(mainName)]
+		if badMains[mainName] {
+			continue
+		}
+		if !ok {
+			report(path, "Source file has no main source file of matching name ("+mainName+fileSuffix+").")
+			continue
 		}
+		declared, err := fileReadNamespace(path)
+		if err != nil {
+			report(path, err.Error())
+			continue
+		}
+		if declared != nsName {
+			report(path, "Source file declares namespace "+string(declared)+", but its main source file declares "+string(nsName)+".")
+			continue
+		}
+		nsFileLookup[nsName] = append(nsFileLookup[nsName], path)
 	}
 
 	// recurse into directories starting with special directory prefix
 	for _, file := range files {
 		if file.IsDir() && strings.HasPrefix(file.Name(), directoryPrefix) {
-			err := buildNamespaceFileLookup(dir+"/"+file.Name(), nsFileLookup)
-			if err != nil {
-				return nil
-			}
+			findSourceFiles(filepath.Join(dir, file.Name()), nsFileLookup, problems)
 		}
 	}
-	return nil
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writes the files (keyed by path relative to the returned directory) of a source tree
+func writeTree(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "bflat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBuildNamespaceFileLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		want     map[NSNameFull][]string // paths relative to the tree
+		problems []string                // substrings of the reported problems, in order
+	}{
+		{
+			name: "multi-file namespace",
+			files: map[string]string{
+				"example.bf":             "example\n\n",
+				"another_example.bf":     "example\n\n",
+				"yet_another_example.bf": "example\n\n",
+				"otherspace.bf":          "otherspace\n\n",
+			},
+			want: map[NSNameFull][]string{
+				"example":    {"example.bf", "another_example.bf", "yet_another_example.bf"},
+				"otherspace": {"otherspace.bf"},
+			},
+		},
+		{
+			name: "bf. subdirectories",
+			files: map[string]string{
+				"game.bf":             "my.game\n\n",
+				"bf.lib/util.bf":      "my.util\n\n",
+				"bf.lib/more_util.bf": "my.util\n\n",
+				"other/skipped.bf":    "my.skipped\n\n",
+			},
+			want: map[NSNameFull][]string{
+				"my.game": {"game.bf"},
+				"my.util": {"bf.lib/util.bf", "bf.lib/more_util.bf"},
+			},
+		},
+		{
+			name: "every bad file reported",
+			files: map[string]string{
+				"example.bf":        "example\n\n",
+				"orphan_missing.bf": "missing\n\n",
+				"wrong_example.bf":  "otherspace\n\n",
+				"misnamed.bf":       "something\n\n",
+				"other_misnamed.bf": "something\n\n", // not reported again for its bad main file
+			},
+			problems: []string{
+				"misnamed.bf: Source file has wrong name for its declared namespace something (expecting something.bf).",
+				"orphan_missing.bf: Source file has no main source file of matching name (missing.bf).",
+				"wrong_example.bf: Source file declares namespace otherspace, but its main source file declares example.",
+			},
+		},
+	}
+	for _, test := range tests {
+		dir := writeTree(t, test.files)
+		defer os.RemoveAll(dir)
+		lookup := map[NSNameFull][]string{}
+		err := buildNamespaceFileLookup(dir, lookup)
+		if test.problems != nil {
+			if err == nil {
+				t.Errorf("%s: expected problems, got none", test.name)
+				continue
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(test.problems) {
+				t.Errorf("%s: expected %d problems, got:\n%v", test.name, len(test.problems), err)
+				continue
+			}
+			for i, problem := range test.problems {
+				if !strings.HasSuffix(lines[i], filepath.FromSlash(problem)) {
+					t.Errorf("%s: problem %d is %q, expected it to end %q", test.name, i, lines[i], problem)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := map[NSNameFull][]string{}
+		for nsName, paths := range lookup {
+			for _, path := range paths {
+				rel, _ := filepath.Rel(dir, path)
+				got[nsName] = append(got[nsName], filepath.ToSlash(rel))
+			}
+			// the main file comes first; the order of the others does not matter
+			sort.Strings(got[nsName][1:])
+		}
+		for _, paths := range test.want {
+			sort.Strings(paths[1:])
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
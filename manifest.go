@@ -0,0 +1,277 @@
+package main
+
+// the project manifest, bflat.toml, e.g.:
+//
+//	[project]
+//	namespaces = ["game.main"]     # the namespaces to compile (with everything they import)
+//	sources = ["src", "lib"]       # directories searched for source files (default: the current directory)
+//	stubs = ["stubs"]              # directories of namespaces declaring external C# code, for which none is generated
+//
+//	[output]
+//	dir = "Assets/Generated"
+//	split = true                   # one file per class, struct or interface
+//	language = "7.3"               # the target C# version (default: latest)
+//	defines = ["UNITY_EDITOR"]     # preprocessor symbols defined in every generated file
+//
+//	[warnings]
+//	errors = true                  # fail the build on any warning
+//
+// only the subset of TOML needed is read: tables, and keys whose values are strings, booleans or arrays of strings
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const manifestFilename = "bflat.toml"
+
+type manifest struct {
+	Namespaces []NSNameFull
+	Sources    []string
+	Stubs      []string
+	Options    buildOptions
+}
+
+// a nil manifest if the file does not exist
+func readManifest(file string) (*manifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{Options: buildOptions{OutDir: "."}}
+	table := ""
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimSpace(stripTomlComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, manifestError(file, lineNum, "Expecting ] at end of table header.")
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			switch table {
+			case "project", "output", "warnings":
+			default:
+				return nil, manifestError(file, lineNum, "Unknown table: "+table)
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq == -1 {
+			return nil, manifestError(file, lineNum, "Expecting key = value.")
+		}
+		key := strings.TrimSpace(line[:eq])
+		text := strings.TrimSpace(line[eq+1:])
+		// an array may continue over the following lines
+		for strings.HasPrefix(text, "[") && !tomlArrayClosed(text) && i+1 < len(lines) {
+			i++
+			text += " " + strings.TrimSpace(stripTomlComment(lines[i]))
+		}
+		value, err := parseTomlValue(text)
+		if err != nil {
+			return nil, manifestError(file, lineNum, err.Error())
+		}
+		err = m.set(table, key, value)
+		if err != nil {
+			return nil, manifestError(file, lineNum, err.Error())
+		}
+	}
+	for _, namespace := range m.Namespaces {
+		if !isFullNamespace(string(namespace)) {
+			return nil, errors.New(file + ": Improperly formed namespace name: " + string(namespace))
+		}
+	}
+	for _, define := range m.Options.Defines {
+		if !isDefineSymbol(define) {
+			return nil, errors.New(file + ": Improperly formed preprocessor symbol: " + define)
+		}
+	}
+	if m.Options.LangVersion != "" && !isLangVersion(m.Options.LangVersion) {
+		return nil, errors.New(file + ": Unknown C# language version: " + m.Options.LangVersion)
+	}
+	return m, nil
+}
+
+func manifestError(file string, line int, s string) error {
+	return errors.New(file + " line " + strconv.Itoa(line) + ": " + s)
+}
+
+// value is a string, a bool or a []string
+func (m *manifest) set(table string, key string, value interface{}) error {
+	var ok bool
+	switch table + "." + key {
+	case "project.namespaces":
+		var names []string
+		names, ok = value.([]string)
+		for _, name := range names {
+			m.Namespaces = append(m.Namespaces, NSNameFull(name))
+		}
+	case "project.sources":
+		m.Sources, ok = value.([]string)
+	case "project.stubs":
+		m.Stubs, ok = value.([]string)
+	case "output.dir":
+		m.Options.OutDir, ok = value.(string)
+	case "output.split":
+		m.Options.Split, ok = value.(bool)
+	case "output.language":
+		m.Options.LangVersion, ok = value.(string)
+	case "output.defines":
+		m.Options.Defines, ok = value.([]string)
+	case "warnings.errors":
+		m.Options.WarningsAsErrors, ok = value.(bool)
+	default:
+		if table == "" {
+			return errors.New("Key outside of any table: " + key)
+		}
+		return errors.New("Unknown key in table " + table + ": " + key)
+	}
+	if !ok {
+		return errors.New("Value of wrong type for key: " + key)
+	}
+	return nil
+}
+
+// the line without a # comment (a # in a string does not begin a comment)
+func stripTomlComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // the escaped character
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func tomlArrayClosed(text string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // the escaped character
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+func parseTomlValue(text string) (interface{}, error) {
+	switch {
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, errors.New("Expecting ] at end of array.")
+		}
+		elems := []string{}
+		rest := strings.TrimSpace(text[1 : len(text)-1])
+		for rest != "" {
+			s, n, err := parseTomlString(rest)
+			if err != nil {
+				return nil, errors.New("Array can contain only strings: " + err.Error())
+			}
+			elems = append(elems, s)
+			rest = strings.TrimSpace(rest[n:])
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if rest != "" {
+				return nil, errors.New("Expecting , between array elements.")
+			}
+		}
+		return elems, nil
+	}
+	s, n, err := parseTomlString(text)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(text) {
+		return nil, errors.New("Unexpected text after value.")
+	}
+	return s, nil
+}
+
+// the string at the start of text and its length in text
+func parseTomlString(text string) (string, int, error) {
+	if strings.HasPrefix(text, "'") {
+		end := strings.Index(text[1:], "'")
+		if end == -1 {
+			return "", 0, errors.New("Unterminated string.")
+		}
+		return text[1 : end+1], end + 2, nil
+	}
+	if !strings.HasPrefix(text, "\"") {
+		return "", 0, errors.New("Expecting a string, true or false.")
+	}
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			s, err := strconv.Unquote(text[:i+1])
+			if err != nil {
+				return "", 0, errors.New("Invalid escape in string.")
+			}
+			return s, i + 1, nil
+		}
+	}
+	return "", 0, errors.New("Unterminated string.")
+}
+
+// a C# identifier, e.g. UNITY_EDITOR
+func isDefineSymbol(symbol string) bool {
+	for i, r := range symbol {
+		if !(r == '_' || unicode.IsLetter(r) || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return symbol != ""
+}
+
+// e.g. 7.3, 10, latest
+func isLangVersion(version string) bool {
+	if version == "latest" || version == "preview" {
+		return true
+	}
+	_, err := strconv.ParseFloat(version, 64)
+	return err == nil
+}
+
+// true if the target C# version is at least major (an unspecified version is the latest)
+func langAtLeast(version string, major int) bool {
+	if version == "" || version == "latest" || version == "preview" {
+		return true
+	}
+	v, err := strconv.ParseFloat(version, 64)
+	return err == nil && int(v) >= major
+}
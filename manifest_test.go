@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     *manifest
+		err      string // following the file name
+	}{
+		{
+			name: "every key",
+			manifest: `[project]
+namespaces = ["game.main"]   # compiled with their imports
+sources = [
+    "src",   # the game
+    "lib",
+]
+stubs = ['stubs']
+
+[output]
+dir = "out # not a comment"
+split = true
+language = "7.3"
+defines = ["UNITY_EDITOR"]
+
+[warnings]
+errors = true
+`,
+			want: &manifest{
+				Namespaces: []NSNameFull{"game.main"},
+				Sources:    []string{"src", "lib"},
+				Stubs:      []string{"stubs"},
+				Options: buildOptions{
+					OutDir:           "out # not a comment",
+					Split:            true,
+					LangVersion:      "7.3",
+					Defines:          []string{"UNITY_EDITOR"},
+					WarningsAsErrors: true,
+				},
+			},
+		},
+		{
+			name:     "unknown table",
+			manifest: "[project]\nnamespaces = []\n\n[build]\n",
+			err:      " line 4: Unknown table: build",
+		},
+		{
+			name:     "unclosed table header",
+			manifest: "[project\n",
+			err:      " line 1: Expecting ] at end of table header.",
+		},
+		{
+			name:     "key outside any table",
+			manifest: "# bflat\nsplit = true\n",
+			err:      " line 2: Key outside of any table: split",
+		},
+		{
+			name:     "unknown key",
+			manifest: "[output]\nsplit = true\nlang = \"7.3\"\n",
+			err:      " line 3: Unknown key in table output: lang",
+		},
+		{
+			name:     "wrong type",
+			manifest: "[output]\nsplit = \"yes\"\n",
+			err:      " line 2: Value of wrong type for key: split",
+		},
+		{
+			name:     "missing value",
+			manifest: "[output]\nsplit\n",
+			err:      " line 2: Expecting key = value.",
+		},
+		{
+			name:     "error in an array reported on its first line",
+			manifest: "[project]\nsources = [\n    \"src\"\n    \"lib\",\n]\n",
+			err:      " line 2: Expecting , between array elements.",
+		},
+		{
+			name:     "unterminated string",
+			manifest: "[output]\ndir = \"out\n",
+			err:      " line 2: Unterminated string.",
+		},
+		{
+			name:     "bad namespace",
+			manifest: "[project]\nnamespaces = [\"Game\"]\n",
+			err:      ": Improperly formed namespace name: Game",
+		},
+		{
+			name:     "bad language version",
+			manifest: "[output]\nlanguage = \"seven\"\n",
+			err:      ": Unknown C# language version: seven",
+		},
+	}
+	for _, test := range tests {
+		dir := writeTree(t, map[string]string{manifestFilename: test.manifest})
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, manifestFilename)
+		m, err := readManifest(file)
+		if test.err != "" {
+			if err == nil || err.Error() != file+test.err {
+				t.Errorf("%s: got error %v, expected %q", test.name, err, file+test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, test.want) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, m, test.want)
+		}
+	}
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	m, err := readManifest(filepath.Join(os.TempDir(), "no-such-dir", manifestFilename))
+	if m != nil || err != nil {
+		t.Errorf("got %v and error %v, expected neither", m, err)
+	}
+}
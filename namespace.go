@@ -83,6 +83,14 @@ func (ns *Namespace) GetMethods(short ShortName, nsShort NSNameShort) []*Callabl
 }
 
 func (ns *Namespace) GetType(ta TypeAtom) Type {
+	if ta.Nullable {
+		ta.Nullable = false
+		base := ns.GetType(ta)
+		if base == nil {
+			return nil
+		}
+		return NullableType{BaseType: base}
+	}
 	if c := ns.GetClass(ta.Name, ta.Namespace); c != nil {
 		return c
 	}
@@ -115,6 +123,24 @@ func (ns *Namespace) GetType(ta TypeAtom) Type {
 			return ByteType
 		case "SB":
 			return SignedByteType
+		case "S":
+			return ShortType
+		case "US":
+			return UnsignedShortType
+		case "UI":
+			return UnsignedIntType
+		case "UII":
+			return UnsignedLongType
+		case "D":
+			return DecimalType
+		case "C":
+			return CharType
+		case "Bool":
+			return BoolType
+		case "Any":
+			return AnyType
+		case "Type":
+			return TypeType
 		}
 	}
 	return nil
@@ -142,7 +168,8 @@ func (ns *Namespace) HasName(short ShortName) bool {
 	return false
 }
 
-func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NSNameFull][]string, namespaces map[NSNameFull]*Namespace) (*Namespace, error) {
+// the imported namespaces must already be in namespaces
+func createNamespace(topDefs *TopDefs, namespace NSNameFull, namespaces map[NSNameFull]*Namespace) (*Namespace, error) {
 
 	nsNameComponents := strings.Split(string(namespace), ".")
 	var shortName NSNameShort
@@ -171,26 +198,53 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 		Constructors: map[ShortName][]*CallableInfo{},
 		Funcs:        map[ShortName][]*CallableInfo{},
 		Methods:      map[ShortName][]*CallableInfo{},
+		Helpers:      map[string][]*CallableInfo{},
+		MemberNames:  map[sourcePosition]bool{},
+		LocalTypes:   map[sourcePosition]Type{},
+		ValueTypes:   map[sourcePosition]Type{},
+		NilAccesses:  map[sourcePosition]bool{},
 	}
 	ns.Imports[shortName] = ns
 
 	for _, importDef := range topDefs.Imports {
-		if _, ok := ns.Imports[importDef.Shortname]; ok {
-			return nil, errors.New("Name collision between imported namespace short names: " + string(importDef.Shortname))
-		}
-
 		foreign, ok := namespaces[importDef.Namespace]
 		if !ok {
-			err := compileNamespace(importDef.Namespace, nsFileLookup, namespaces)
-			if err != nil {
-				return nil, err
+			// should be impossible: the imports are compiled first
+			return nil, msg(importDef.Line, importDef.Column, "Imported namespace has not been compiled: "+string(importDef.Namespace))
+		}
+
+		// without -shortname, the foreign namespace is qualified by the last component of its name
+		shortname := importDef.Shortname
+		if shortname == "" {
+			shortname = foreign.ShortName
+		}
+		if _, ok := ns.Imports[shortname]; ok {
+			return nil, msg(importDef.Line, importDef.Column, "Name collision between imported namespace short names: "+string(shortname))
+		}
+		ns.Imports[shortname] = foreign
+
+		// the excluded and aliased names which the foreign namespace defines
+		found := map[string]bool{}
+		importedName := func(name ShortName) (ShortName, bool) {
+			for _, excluded := range importDef.Exclusions {
+				if excluded == string(name) {
+					found[excluded] = true
+					return "", false
+				}
 			}
-			foreign = namespaces[importDef.Namespace]
+			if alias, ok := importDef.Aliases[string(name)]; ok {
+				found[string(name)] = true
+				return ShortName(alias), true
+			}
+			return name, true
 		}
-		ns.Imports[importDef.Shortname] = foreign
 
 		for name, interfaceInfo := range foreign.Interfaces {
 			if interfaceInfo.Namespace == foreign {
+				name, ok := importedName(name)
+				if !ok {
+					continue
+				}
 				if ns.HasName(name) {
 					return nil, errors.New("Name collision: " + string(name) + " imported from more than one namespaces.")
 				}
@@ -200,6 +254,10 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 
 		for name, classInfo := range foreign.Classes {
 			if classInfo.Namespace == foreign {
+				name, ok := importedName(name)
+				if !ok {
+					continue
+				}
 				if ns.HasName(name) {
 					return nil, errors.New("Name collision: " + string(name) + " imported from more than one namespaces.")
 				}
@@ -209,6 +267,10 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 
 		for name, structInfo := range foreign.Structs {
 			if structInfo.Namespace == foreign {
+				name, ok := importedName(name)
+				if !ok {
+					continue
+				}
 				if ns.HasName(name) {
 					return nil, errors.New("Name collision: " + string(name) + " imported from more than one namespaces.")
 				}
@@ -218,6 +280,10 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 
 		for name, globalInfo := range foreign.Globals {
 			if globalInfo.Namespace == foreign {
+				name, ok := importedName(name)
+				if !ok {
+					continue
+				}
 				if ns.HasName(name) {
 					return nil, errors.New("Name collision: " + string(name) + " imported from more than one namespaces.")
 				}
@@ -231,15 +297,21 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 		for name, callables := range foreign.Funcs {
 			for _, callable := range callables {
 				if callable.Namespace == foreign {
+					name, ok := importedName(name)
+					if !ok {
+						continue
+					}
 					ns.Funcs[name] = append(ns.Funcs[name], callable)
 				}
 			}
 		}
 
+		// the constructors are known by the name of their class or struct (whose collisions are checked above)
 		for name, callables := range foreign.Constructors {
 			if callables[0].Namespace == foreign {
-				if ns.HasName(name) {
-					return nil, errors.New("Name collision: " + string(name) + " imported from more than one namespaces.")
+				name, ok := importedName(name)
+				if !ok {
+					continue
 				}
 				ns.Constructors[name] = callables
 			}
@@ -253,6 +325,17 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 				}
 			}
 		}
+
+		for _, excluded := range importDef.Exclusions {
+			if !found[excluded] {
+				return nil, msg(importDef.Line, importDef.Column, "Import excludes name not defined in namespace "+string(importDef.Namespace)+": "+excluded)
+			}
+		}
+		for original := range importDef.Aliases {
+			if !found[original] {
+				return nil, msg(importDef.Line, importDef.Column, "Import aliases name not defined in namespace "+string(importDef.Namespace)+": "+original)
+			}
+		}
 	}
 
 	for _, interfaceDef := range topDefs.Interfaces {
@@ -466,6 +549,12 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 			}
 		}
 
+		indexers, err := getIndexers(classDef.Indexers, "class", ns)
+		if err != nil {
+			return nil, err
+		}
+		classInfo.Indexers = indexers
+
 		hasZeroArgConstructor := false
 
 		constructorSigs := [][]Type{}
@@ -614,12 +703,18 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 		ns.Funcs[fn.Name] = append(ns.Funcs[fn.Name],
 			&CallableInfo{
 				IsMethod:   false,
+				Name:       fn.Name,
 				Namespace:  ns,
 				ParamNames: fn.ParamNames,
 				ParamTypes: types,
 				Return:     returnType,
 			},
 		)
+
+		err = addHelpers(fn, string(fn.Name), ns)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// init global Type fields
@@ -711,13 +806,19 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 			structInfo.Properties[p.Name] = PropertyInfo{
 				Name:        p.Name,
 				Type:        t,
-				HasGetter:   len(p.GetBody) > 0,
-				HasSetter:   len(p.SetBody) > 0,
+				HasGetter:   p.HasGetter,
+				HasSetter:   p.HasSetter,
 				AccessLevel: p.AccessLevel,
 				Static:      staticType,
 			}
 		}
 
+		indexers, err := getIndexers(structDef.Indexers, "struct", ns)
+		if err != nil {
+			return nil, err
+		}
+		structInfo.Indexers = indexers
+
 		hasZeroArgConstructor := false
 
 		constructorSigs := [][]Type{}
@@ -839,6 +940,58 @@ func createNamespace(topDefs *TopDefs, namespace NSNameFull, nsFileLookup map[NS
 	return ns, nil
 }
 
+// registers the helpers of owner (and recursively their helpers) in ns.Helpers
+// prefix is the mangled name of owner
+func addHelpers(owner FuncDef, prefix string, ns *Namespace) error {
+	for _, helper := range owner.Helpers {
+		// helpers of the same name under one owner are overloads, but helpers of
+		// different owners (e.g. overloads of the owner) would collide once mangled
+		mangled := prefix + HelperSeparator + string(helper.Name)
+		if _, ok := ns.Helpers[mangled]; ok {
+			return msg(helper.Line, helper.Column, "Helper name is already used by a helper of another function with the same name: "+string(helper.Name))
+		}
+	}
+	helperSigs := map[ShortName][][]Type{}
+	for _, helper := range owner.Helpers {
+		var returnType Type
+		if helper.Return.Name != "" {
+			returnType = ns.GetType(helper.Return)
+			if returnType == nil {
+				return msg(helper.Line, helper.Column, "Function return type is of unknown type:"+string(helper.Return.Name)+"/"+string(helper.Return.Namespace))
+			}
+		}
+
+		types, err := getParamTypes(helper.ParamTypes, ns)
+		if err != nil {
+			return err
+		}
+
+		if signatureConflict(types, helperSigs[helper.Name]) {
+			return msg(helper.Line, helper.Column, "Two or more helpers of the same function have the same name and parameter types, so all calls would be ambiguous: "+string(helper.Name))
+		}
+
+		helperSigs[helper.Name] = append(helperSigs[helper.Name], types)
+
+		mangled := prefix + HelperSeparator + string(helper.Name)
+		ns.Helpers[mangled] = append(ns.Helpers[mangled],
+			&CallableInfo{
+				IsMethod:   false,
+				Namespace:  ns,
+				ParamNames: helper.ParamNames,
+				ParamTypes: types,
+				Return:     returnType,
+				Mangled:    mangled,
+			},
+		)
+
+		err = addHelpers(helper, mangled, ns)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func signatureConflict(sigTypes []Type, otherSigTypes [][]Type) bool {
 	for _, otherTypes := range otherSigTypes {
 		if len(otherTypes) != len(sigTypes) {
@@ -870,6 +1023,83 @@ func getParamTypes(typeAtoms []TypeAtom, ns *Namespace) ([]Type, error) {
 	return types, nil
 }
 
+func getIndexers(indexerDefs []IndexerDef, structOrClass string, ns *Namespace) ([]*IndexerInfo, error) {
+	indexers := []*IndexerInfo{}
+	indexerSigs := [][]Type{}
+	for _, indexer := range indexerDefs {
+		t := ns.GetType(indexer.Type)
+		if t == nil {
+			return nil, msg(indexer.Line, indexer.Column, "Indexer has unknown type.")
+		}
+
+		types, err := getParamTypes(indexer.ParamTypes, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		if signatureConflict(types, indexerSigs) {
+			return nil, msg(indexer.Line, indexer.Column, "Two or more indexers of the same "+structOrClass+" have the same parameter types, so all indexing would be ambiguous.")
+		}
+
+		indexerSigs = append(indexerSigs, types)
+
+		indexers = append(indexers, &IndexerInfo{
+			Type:       t,
+			ParamNames: indexer.ParamNames,
+			ParamTypes: types,
+			HasGetter:  indexer.HasGetter,
+			HasSetter:  indexer.HasSetter,
+		})
+	}
+	return indexers, nil
+}
+
+// returns the indexers of a class (including those inherited from ancestors) or struct
+// an inherited indexer is hidden by a descendent indexer with the same parameter types
+func GetIndexers(t Type) []*IndexerInfo {
+	switch t := t.(type) {
+	case *ClassInfo:
+		indexers := []*IndexerInfo{}
+		sigs := [][]Type{}
+		for ; t != nil; t = t.Parent {
+			for _, indexer := range t.Indexers {
+				if signatureConflict(indexer.ParamTypes, sigs) {
+					continue
+				}
+				sigs = append(sigs, indexer.ParamTypes)
+				indexers = append(indexers, indexer)
+			}
+		}
+		return indexers
+	case *StructInfo:
+		return t.Indexers
+	}
+	return nil
+}
+
+// returns true if the class (or one of its ancestors) or struct has a field or property of the name
+func HasFieldOrProperty(field ShortName, t Type) bool {
+	switch t := t.(type) {
+	case *ClassInfo:
+		for ; t != nil; t = t.Parent {
+			if _, ok := t.Fields[field]; ok {
+				return true
+			}
+			if _, ok := t.Properties[field]; ok {
+				return true
+			}
+		}
+	case *StructInfo:
+		if _, ok := t.Fields[field]; ok {
+			return true
+		}
+		if _, ok := t.Properties[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // returns true if field exists
 // todo: account for access level
 func GetFieldOrPropertyType(field ShortName, t Type, isTarget bool, static bool) (Type, bool, error) {
@@ -1030,13 +1260,31 @@ func IsDescendent(t Type, other Type) bool {
 	return false
 }
 
+// a nullable T converts implicitly to T only if T is a reference type (flow analysis warns where
+// a value which may be nil is dereferenced), and nil is a value only of the nullable types
 func IsSubType(t Type, other Type) bool {
+	if t == NilType {
+		_, ok := other.(NullableType)
+		return ok
+	}
 	if other == AnyType {
 		return true
 	}
 	if t == other {
 		return true
 	}
+	if n, ok := other.(NullableType); ok {
+		if tn, ok := t.(NullableType); ok {
+			t = tn.BaseType
+		}
+		return IsSubType(t, n.BaseType)
+	}
+	if n, ok := t.(NullableType); ok {
+		return IsReferenceType(n.BaseType) && IsSubType(n.BaseType, other)
+	}
+	if IsNumberWidening(t, other) {
+		return true
+	}
 	if IsDescendent(t, other) {
 		return true
 	}
@@ -1052,6 +1300,88 @@ func IsSubType(t Type, other Type) bool {
 	return false
 }
 
+// true if a value of type from can be explicitly cast to type to
+func IsCastable(from Type, to Type) bool {
+	if from == NilType {
+		return IsSubType(from, to)
+	}
+	if n, ok := from.(NullableType); ok {
+		from = n.BaseType
+	}
+	if n, ok := to.(NullableType); ok {
+		to = n.BaseType
+	}
+	if (IsNumber(from) || from == CharType) && (IsNumber(to) || to == CharType) {
+		return true
+	}
+	if IsSubType(from, to) || IsSubType(to, from) {
+		return true
+	}
+	// a class which doesn't implement an interface may have a descendent which does
+	_, fromInterface := from.(*InterfaceInfo)
+	_, toInterface := to.(*InterfaceInfo)
+	_, fromClass := from.(*ClassInfo)
+	_, toClass := to.(*ClassInfo)
+	return (fromInterface && (toInterface || toClass)) || (toInterface && fromClass)
+}
+
+// the narrowest type of which both types are subtypes (nil if there is none but Any)
+func CommonSuperType(a Type, b Type) Type {
+	if a == NilType && b != NilType {
+		return NullableOf(b)
+	}
+	if b == NilType && a != NilType {
+		return NullableOf(a)
+	}
+	_, aNullable := a.(NullableType)
+	_, bNullable := b.(NullableType)
+	if aNullable || bNullable {
+		if t := CommonSuperType(NonNullable(a), NonNullable(b)); t != nil {
+			return NullableOf(t)
+		}
+		return nil
+	}
+	if IsSubType(a, b) {
+		return b
+	}
+	if IsSubType(b, a) {
+		return a
+	}
+	if class, ok := a.(*ClassInfo); ok {
+		for parent := class.Parent; parent != nil; parent = parent.Parent {
+			if IsSubType(b, parent) {
+				return parent
+			}
+		}
+	}
+	return nil
+}
+
+// T? for type T (which is returned as is if already nullable)
+func NullableOf(t Type) Type {
+	if _, ok := t.(NullableType); ok {
+		return t
+	}
+	return NullableType{BaseType: t}
+}
+
+// T for T? (other types are returned as is)
+func NonNullable(t Type) Type {
+	if n, ok := t.(NullableType); ok {
+		return n.BaseType
+	}
+	return t
+}
+
+// true if values of the type are references (and so can be null); a nullable value type is not
+func IsReferenceType(t Type) bool {
+	switch t.(type) {
+	case *ClassInfo, *InterfaceInfo, ArrayType:
+		return true
+	}
+	return t == StrType || t == AnyType || t == TypeType
+}
+
 // return base type and dimension
 func GetArrayType(arr ArrayType) (base Type, dimensions int) {
 	dimensions = 1
@@ -1066,18 +1396,61 @@ func IsNumber(t Type) bool {
 	switch t := t.(type) {
 	case BuiltinType:
 		switch t.Name {
-		case "I", "II", "F", "FF", "B", "SB":
+		case "I", "II", "F", "FF", "B", "SB", "S", "US", "UI", "UII", "D":
 			return true
 		}
 	}
 	return false
 }
 
+// the number type of the name, e.g. UI (nil if not a number type)
+func numberTypeByName(name ShortName) Type {
+	for _, t := range numberTypes {
+		if t.(BuiltinType).Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// true if number type t implicitly converts to the wider number type other
+func IsNumberWidening(t Type, other Type) bool {
+	tb, ok := t.(BuiltinType)
+	if !ok {
+		return false
+	}
+	ob, ok := other.(BuiltinType)
+	if !ok {
+		return false
+	}
+	for _, name := range numberWidenings[tb.Name] {
+		if name == ob.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// the narrowest number type to which both number types implicitly convert (nil if there is none);
+// as in C#, the common type of two integers must be an integer, so e.g. II and UII have none
+func WidestNumberType(a Type, b Type) Type {
+	bothIntegers := IsInteger(a) && IsInteger(b)
+	for _, t := range numberTypes {
+		if bothIntegers && !IsInteger(t) {
+			continue
+		}
+		if (a == t || IsNumberWidening(a, t)) && (b == t || IsNumberWidening(b, t)) {
+			return t
+		}
+	}
+	return nil
+}
+
 func IsInteger(t Type) bool {
 	switch t := t.(type) {
 	case BuiltinType:
 		switch t.Name {
-		case "I", "II", "B", "SB":
+		case "I", "II", "B", "SB", "S", "US", "UI", "UII":
 			return true
 		}
 	}
@@ -1,66 +1,40 @@
 package main
 
+import "strings"
+
 func compileOperation(op CallForm, ns *Namespace, expectedType Type,
 	locals map[ShortName]Type) (string, Type, error) {
 	if op.Namespace != "" {
 		return "", nil, msg(op.Line, op.Column, "Call to unknown method or function.")
 	}
-	returnType := expectedType
-	expectedArgType := expectedType
+	switch op.Name {
+	case "ife":
+		return compileIfe(op, ns, expectedType, locals)
+	case "coalesce":
+		return compileCoalesce(op, ns, expectedType, locals)
+	case "cast", "istype", "astype", "typeof", "sizeof", "default":
+		return compileTypeOperation(op, ns, locals)
+	case "shl", "shr":
+		return compileShift(op, ns, expectedType, locals)
+	case "add", "sub", "mul", "div", "mod", "band", "bor", "bxor", "inc", "dec", "bnot",
+		"lt", "gt", "lte", "gte":
+		return compileNumberOperation(op, ns, expectedType, locals)
+	}
+	var returnType Type
+	var expectedArgType Type
 	multiOperand := true
 	switch op.Name {
-	case "add", "sub", "mul", "div":
-		if expectedType == nil {
-			returnType = LongType
-			expectedType = LongType
-		}
-		if !IsNumber(expectedType) {
-			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation used where non-number expected")
-		}
-	case "lt", "lte", "gt", "gte":
-		if expectedType == nil {
-			returnType = BoolType
-			expectedType = BoolType
-		}
-		if !IsNumber(expectedType) {
-			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation used where non-number expected")
-		}
-		expectedArgType = DoubleType // todo: actually need type which is supertype of all numbers (Long is not subtype of Double)
-	case "inc", "dec":
-		if expectedType == nil {
-			returnType = LongType
-			expectedType = LongType
-		}
-		multiOperand = false
-		if !IsInteger(expectedType) {
-			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation used where non-number expected")
-		}
-	case "mod", "band", "bor", "bxor":
-		if expectedType == nil {
-			returnType = LongType
-			expectedType = LongType
-		}
-		if !IsInteger(expectedType) {
-			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation used where non-number expected")
-		}
-	case "bnot":
-		if expectedType == nil {
-			returnType = LongType
-			expectedType = LongType
-		}
-		if !IsInteger(expectedType) {
-			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation used where non-number expected")
-		}
-		multiOperand = false
 	case "eq", "neq":
-		expectedArgType = nil
 		returnType = BoolType
 	case "not":
 		multiOperand = false
+		returnType = BoolType
 		expectedArgType = BoolType
-	case "or", "and":
+	case "or", "and", "xor":
+		returnType = BoolType
 		expectedArgType = BoolType
 	case "cat":
+		returnType = StrType
 		expectedArgType = StrType
 	default:
 		return "", nil, msg(op.Line, op.Column, "Unknown operator, function, or method.")
@@ -85,7 +59,7 @@ func compileOperation(op CallForm, ns *Namespace, expectedType Type,
 	}
 	code := "("
 	switch op.Name {
-	case "add", "sub", "mul", "div", "mod", "and", "or", "band", "bor", "bxor", "cat":
+	case "and", "or", "xor", "cat":
 		operatorSymbol := OperatorSymbols[op.Name]
 		for i := range op.Args {
 			code += operandCode[i]
@@ -93,14 +67,22 @@ func compileOperation(op CallForm, ns *Namespace, expectedType Type,
 				code += operatorSymbol
 			}
 		}
-	case "inc":
-		code += operandCode[0] + " + 1"
-	case "dec":
-		code += operandCode[0] + " - 1"
 	case "eq", "neq":
+		numbers := true
+		for _, t := range operandTypes {
+			numbers = numbers && IsNumber(t)
+		}
+		if numbers {
+			// compared as their common type
+			var err error
+			operandCode, _, err = compileNumberOperands(op, ns, nil, locals)
+			if err != nil {
+				return "", nil, err
+			}
+		}
 		operatorSymbol := OperatorSymbols[op.Name]
 		for i := 0; i < len(op.Args)-1; i++ {
-			if operandTypes[i+1] != operandTypes[0] {
+			if !numbers && CommonSuperType(operandTypes[i+1], operandTypes[0]) == nil {
 				return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation has mismatched operand types")
 			}
 			if i > 0 {
@@ -110,6 +92,48 @@ func compileOperation(op CallForm, ns *Namespace, expectedType Type,
 		}
 	case "not":
 		code += "!" + operandCode[0]
+	}
+	code += ")"
+	return code, returnType, nil
+
+}
+
+// arithmetic, bitwise and comparison operations: the operands are of their common number type
+// (see compileNumberOperands), which is also the type of the result, except comparisons return Bool
+func compileNumberOperation(op CallForm, ns *Namespace, expectedType Type,
+	locals map[ShortName]Type) (string, Type, error) {
+	unary := op.Name == "inc" || op.Name == "dec" || op.Name == "bnot"
+	if unary && len(op.Args) != 1 {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires one operand")
+	}
+	if !unary && len(op.Args) < 2 {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires at least two operands")
+	}
+	switch op.Name {
+	case "lt", "gt", "lte", "gte":
+		if expectedType != nil && !IsSubType(BoolType, expectedType) {
+			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation used where non-Bool expected")
+		}
+		expectedType = nil
+	}
+	operandCode, t, err := compileNumberOperands(op, ns, expectedType, locals)
+	if err != nil {
+		return "", nil, err
+	}
+	switch op.Name {
+	case "mod", "band", "bor", "bxor", "inc", "dec", "bnot":
+		if !IsInteger(t) {
+			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires integer operands")
+		}
+	}
+	code := "("
+	switch op.Name {
+	case "inc":
+		code += operandCode[0] + " + 1"
+	case "dec":
+		code += operandCode[0] + " - 1"
+	case "bnot":
+		code += "~" + operandCode[0]
 	case "lt", "gt", "gte", "lte":
 		operatorSymbol := OperatorSymbols[op.Name]
 		for i := 0; i < len(op.Args)-1; i++ {
@@ -119,18 +143,264 @@ func compileOperation(op CallForm, ns *Namespace, expectedType Type,
 			// todo: should use variables to store the compiled expressions because otherwise we're potentially repeating complex expressions
 			code += operandCode[i] + operatorSymbol + operandCode[i+1]
 		}
-	case "bnot":
-		code += "^" + operandCode[1]
+		return code + ")", BoolType, nil
+	default:
+		code += strings.Join(operandCode, OperatorSymbols[op.Name])
 	}
 	code += ")"
-	return code, returnType, nil
+	return narrowResult(code, t), t, nil
+}
+
+// C# does arithmetic on the number types narrower than int as int, so the result is cast back
+func narrowResult(code string, t Type) string {
+	if IsNumberWidening(t, IntType) {
+		return "((" + compileType(t) + ") " + code + ")"
+	}
+	return code
+}
+
+// compiles the operands of a number operation to their common type: the operands other than unsuffixed literals
+// determine the type, which the literals then take (though a fractional literal makes integers FF,
+// and a literal out of range for the type widens it); when all operands are literals, the type is the expected type if a number, else the widest of the literals' own types
+func compileNumberOperands(op CallForm, ns *Namespace, expectedType Type,
+	locals map[ShortName]Type) ([]string, Type, error) {
+	operandCode := make([]string, len(op.Args))
+	var t Type
+	fractional := false
+	for i, expr := range op.Args {
+		if num, ok := expr.(ParsedNumberAtom); ok && num.Value.Suffix == "" {
+			fractional = fractional || num.Value.Fractional
+			continue
+		}
+		c, operandType, err := compileExpression(expr, ns, nil, locals)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !IsNumber(operandType) {
+			return nil, nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires number operands")
+		}
+		operandCode[i] = c
+		if t == nil {
+			t = operandType
+		} else if t = WidestNumberType(t, operandType); t == nil {
+			return nil, nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation has operands with no common number type")
+		}
+	}
+	if t == nil {
+		if IsNumber(expectedType) {
+			t = expectedType
+		} else {
+			for _, expr := range op.Args {
+				_, literalType, err := compileNumberLiteral(expr.(ParsedNumberAtom), nil)
+				if err != nil {
+					return nil, nil, err
+				}
+				if t == nil {
+					t = literalType
+				} else {
+					t = WidestNumberType(t, literalType)
+				}
+			}
+		}
+	}
+	if fractional && IsInteger(t) {
+		t = WidestNumberType(t, DoubleType)
+	}
+	for _, expr := range op.Args {
+		// a literal out of range for the type widens it to the literal's own type, e.g. (add b 300) for a B is I
+		if num, ok := expr.(ParsedNumberAtom); ok && num.Value.Suffix == "" {
+			if _, _, err := compileNumberLiteral(num, t); err != nil {
+				if _, literalType, err := compileNumberLiteral(num, nil); err == nil && WidestNumberType(t, literalType) != nil {
+					t = WidestNumberType(t, literalType)
+				}
+			}
+		}
+	}
+	for i, expr := range op.Args {
+		if num, ok := expr.(ParsedNumberAtom); ok && num.Value.Suffix == "" {
+			var err error
+			operandCode[i], _, err = compileNumberLiteral(num, t)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return operandCode, t, nil
+}
+
+// (shl x n) and (shr x n): the type is that of x (see compileNumberOperands); C# requires the shift amount n be int
+func compileShift(op CallForm, ns *Namespace, expectedType Type,
+	locals map[ShortName]Type) (string, Type, error) {
+	if len(op.Args) != 2 {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires two operands")
+	}
+	valueOp := op
+	valueOp.Args = op.Args[:1]
+	valueCode, t, err := compileNumberOperands(valueOp, ns, expectedType, locals)
+	if err != nil {
+		return "", nil, err
+	}
+	if !IsInteger(t) {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires an integer to shift")
+	}
+	amountCode, amountType, err := compileExpression(op.Args[1], ns, nil, locals)
+	if err != nil {
+		return "", nil, err
+	}
+	if !IsInteger(amountType) {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires an integer shift amount")
+	}
+	if amountType != IntType && !IsNumberWidening(amountType, IntType) {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation shift amount cannot be "+
+			string(amountType.(BuiltinType).Name)+" (cast it to I)")
+	}
+	return narrowResult("("+valueCode[0]+OperatorSymbols[op.Name]+amountCode+")", t), t, nil
+}
 
+// (ife condition a b): the type is the expected type, else the narrowest type of both a and b
+func compileIfe(op CallForm, ns *Namespace, expectedType Type,
+	locals map[ShortName]Type) (string, Type, error) {
+	if len(op.Args) != 3 {
+		return "", nil, msg(op.Line, op.Column, "'ife' operation requires a condition and two operands")
+	}
+	condCode, _, err := compileExpression(op.Args[0], ns, BoolType, locals)
+	if err != nil {
+		return "", nil, err
+	}
+	operandCode := make([]string, 2)
+	operandTypes := make([]Type, 2)
+	for i, expr := range op.Args[1:] {
+		operandCode[i], operandTypes[i], err = compileExpression(expr, ns, expectedType, locals)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	t := expectedType
+	if t == nil {
+		t = CommonSuperType(operandTypes[0], operandTypes[1])
+		if t == nil {
+			return "", nil, msg(op.Line, op.Column, "'ife' operation has operands with no common type")
+		}
+	}
+	// C# requires one operand to convert to the type of the other
+	for i := range operandCode {
+		if operandTypes[i] != t {
+			operandCode[i] = "(" + compileType(t) + ") " + operandCode[i]
+		}
+	}
+	return "(" + condCode + " ? " + operandCode[0] + " : " + operandCode[1] + ")", t, nil
+}
+
+// (coalesce a b c) is the first of the operands which is not nil
+func compileCoalesce(op CallForm, ns *Namespace, expectedType Type,
+	locals map[ShortName]Type) (string, Type, error) {
+	if len(op.Args) < 2 {
+		return "", nil, msg(op.Line, op.Column, "'coalesce' operation requires at least two operands")
+	}
+	operandCode := make([]string, len(op.Args))
+	operandTypes := make([]Type, len(op.Args))
+	var operandType Type // any operand may be nil, so nil may be given for a non-nullable expected type
+	if expectedType != nil {
+		operandType = NullableOf(NonNullable(expectedType))
+	}
+	var t Type
+	for i, expr := range op.Args {
+		var err error
+		operandCode[i], operandTypes[i], err = compileExpression(expr, ns, operandType, locals)
+		if err != nil {
+			return "", nil, err
+		}
+		if i < len(op.Args)-1 {
+			if _, ok := operandTypes[i].(NullableType); !ok && !IsReferenceType(operandTypes[i]) {
+				return "", nil, msg(op.Line, op.Column, "'coalesce' operation has an operand other than the last which cannot be nil")
+			}
+		}
+		if t == nil {
+			t = operandTypes[i]
+		} else if t = CommonSuperType(t, operandTypes[i]); t == nil {
+			return "", nil, msg(op.Line, op.Column, "'coalesce' operation has operands with no common type")
+		}
+	}
+	// only the last operand can make the result nil
+	last := operandTypes[len(op.Args)-1]
+	if _, ok := last.(NullableType); !ok && last != NilType {
+		t = NonNullable(t)
+	}
+	if t == NilType {
+		return "", nil, msg(op.Line, op.Column, "'coalesce' operation has only nil operands")
+	}
+	return "(" + strings.Join(operandCode, " ?? ") + ")", t, nil
+}
+
+// operations whose first operand is a type:
+// (cast T x), (istype T x), (astype T x), (typeof T), (sizeof T), (default T)
+func compileTypeOperation(op CallForm, ns *Namespace, locals map[ShortName]Type) (string, Type, error) {
+	if op.Static.Name == "" {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires a type as its first operand")
+	}
+	t := ns.GetType(op.Static)
+	if t == nil {
+		return "", nil, msg(op.Static.Line, op.Static.Column, "'"+string(op.Name)+"' operation references unknown type.")
+	}
+	typeStr := compileType(t)
+	switch op.Name {
+	case "typeof", "sizeof", "default":
+		if len(op.Args) != 0 {
+			return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation takes only a type")
+		}
+		switch op.Name {
+		case "typeof":
+			return "typeof(" + typeStr + ")", TypeType, nil
+		case "sizeof":
+			if !IsNumber(t) && t != BoolType {
+				return "", nil, msg(op.Line, op.Column, "'sizeof' operation requires a number type or Bool")
+			}
+			return "sizeof(" + typeStr + ")", IntType, nil
+		default:
+			return "default(" + typeStr + ")", t, nil
+		}
+	}
+	if len(op.Args) != 1 {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation requires a type and one operand")
+	}
+	c, operandType, err := compileExpression(op.Args[0], ns, nil, locals)
+	if err != nil {
+		return "", nil, err
+	}
+	// the result of astype is null if the operand is not of the type
+	if _, ok := t.(NullableType); op.Name == "astype" && !ok && !IsReferenceType(t) {
+		return "", nil, msg(op.Line, op.Column, "'astype' operation requires a reference or nullable type (it returns nil on failure)")
+	}
+	if !IsCastable(operandType, t) {
+		return "", nil, msg(op.Line, op.Column, "'"+string(op.Name)+"' operation has operand of type unrelated to "+string(op.Static.Name))
+	}
+	switch op.Name {
+	case "istype":
+		return "(" + c + " is " + typeStr + ")", BoolType, nil
+	case "astype":
+		return "(" + c + " as " + typeStr + ")", NullableOf(t), nil
+	default:
+		return "((" + typeStr + ") " + c + ")", t, nil
+	}
 }
 
 func compileCallForm(op CallForm, ns *Namespace, expectedType Type,
 	locals map[ShortName]Type) (string, Type, error) {
-	sigs := append(ns.GetFuncs(op.Name, op.Namespace), ns.GetMethods(op.Name, op.Namespace)...)
+	var sigs []*CallableInfo
+	if helper, ok := locals[op.Name].(HelperType); ok && op.Namespace == "" {
+		// helper names shadow funcs and methods
+		sigs = ns.Helpers[helper.Mangled]
+	} else {
+		sigs = append(ns.GetFuncs(op.Name, op.Namespace), ns.GetMethods(op.Name, op.Namespace)...)
+	}
 	if len(sigs) == 0 {
+		if op.Namespace == "" {
+			for mangled := range ns.Helpers {
+				if strings.HasSuffix(mangled, HelperSeparator+string(op.Name)) {
+					return "", nil, msg(op.Line, op.Column, "Helper function can only be called from within the function it belongs to: "+string(op.Name))
+				}
+			}
+		}
 		return compileOperation(op, ns, expectedType, locals)
 	}
 
@@ -172,24 +442,34 @@ Loop:
 
 	sig := matching[0]
 	if len(matching) > 1 {
-		var err error
-		sig, err = ClosestMatchingSignature(matching, ns, op.Line, op.Column)
-		if err != nil {
-			return "", nil, err
+		paramLists := make([][]Type, len(matching))
+		for i, sig := range matching {
+			paramLists[i] = sig.ParamTypes
+		}
+		if i := narrowestParams(paramLists); i != -1 {
+			sig = matching[i]
+		} else {
+			var err error
+			sig, err = ClosestMatchingSignature(matching, ns, op.Line, op.Column)
+			if err != nil {
+				return "", nil, err
+			}
 		}
 	}
 
 	isMethod := sig.IsMethod
-	if isMethod {
-		code += argCode[0] + "."
+	if sig.Mangled != "" {
+		// helpers are only called from within the same class
+		code += sig.Mangled + "("
 	} else {
-		if sig.Static == nil {
-			code += string(sig.Namespace.Name) + "." + FuncsClass + "."
+		if isMethod {
+			code += argCode[0] + "." + string(op.Name) + "("
+		} else if sig.Static == nil {
+			code += string(sig.Namespace.CSName) + "." + FuncsClass + "." + string(sig.Name) + "("
 		} else {
-			code += string(sig.Namespace.Name) + "." + compileType(sig.Static) + "."
+			code += compileType(sig.Static) + "." + string(op.Name) + "("
 		}
 	}
-	code += string(op.Name) + "("
 	for i, arg := range argCode {
 		if isMethod && i == 0 {
 			continue
@@ -226,42 +506,17 @@ func compileTypeCallForm(op TypeCallForm, ns *Namespace, expectedType Type,
 	if t == nil {
 		// should be impossible
 		return "", nil, msg(op.Line, op.Column, "Compiling call form starting with zero type.")
-	} else if t == IntType {
-		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
-			err = msg(op.Line, op.Column, "Invalid cast to I.")
+	} else if IsNumber(t) || t == CharType {
+		// a conversion between the number types and C, e.g. (UI x); a literal must be in range for the type
+		if len(op.Args) != 1 || !IsCastable(argTypes[0], t) {
+			err = msg(op.Line, op.Column, "Invalid cast to "+string(t.(BuiltinType).Name)+".")
 			return
 		}
-
-	} else if t == LongType {
-		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
-			err = msg(op.Line, op.Column, "Invalid cast to II.")
-			return
-		}
-
-	} else if t == FloatType {
-		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
-			err = msg(op.Line, op.Column, "Invalid cast to F.")
-			return
-		}
-
-	} else if t == DoubleType {
-		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
-			err = msg(op.Line, op.Column, "Invalid cast to FF.")
-			return
+		if num, ok := op.Args[0].(ParsedNumberAtom); ok && IsNumber(t) {
+			return compileNumberLiteral(num, t)
 		}
-
-	} else if t == ByteType {
-		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
-			err = msg(op.Line, op.Column, "Invalid cast to B.")
-			return
-		}
-
-	} else if t == SignedByteType {
-		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
-			err = msg(op.Line, op.Column, "Invalid cast to SB.")
-			return
-		}
-
+		code = "((" + compileType(t) + ") " + argCode[0] + ")"
+		returnType = t
 	} else if t == BoolType {
 		if len(op.Args) != 1 || !IsNumber(argTypes[0]) {
 			err = msg(op.Line, op.Column, "Invalid cast to Bool.")
@@ -0,0 +1,145 @@
+operators
+
+// each operator compiled to C#: the expected output is operators.cs
+
+(func arithmetic I : a I b I
+    (var sum I (add a b 1))
+    (var difference I (sub a b))
+    (var product I (mul a b))
+    (var quotient I (div a b))
+    (var remainder I (mod a b))
+    (var next I (inc a))
+    (var previous I (dec a))
+    (return (add sum difference product quotient remainder next previous))
+)
+
+(func bitwise I : a I b I
+    (var both I (band a b))
+    (var either I (bor a b))
+    (var one I (bxor a b))
+    (var flipped I (bnot a))
+    (var left I (shl a 2))
+    (var right I (shr a b))
+    (return (bor both either one flipped left right))
+)
+
+(func logic Bool : a Bool b Bool
+    (var both Bool (and a b))
+    (var either Bool (or a b))
+    (var one Bool (xor a b))
+    (var neither Bool (not either))
+    (return (and both one neither))
+)
+
+(func comparison Bool : a I b II
+    (var equal Bool (eq a b))
+    (var unequal Bool (neq a b))
+    (var less Bool (lt a b))
+    (var greater Bool (gt a b))
+    (var lessOrEqual Bool (lte a b 10))
+    (var greaterOrEqual Bool (gte a b))
+    (return (or equal unequal less greater lessOrEqual greaterOrEqual))
+)
+
+(func promotion FF : a B b SB c I d II e F
+    (var bytes B (add a 1))
+    (var signed I (sub a b))
+    (var wide II (mul c d))
+    (var single F (div e c))
+    (var precise FF (add d 0.5))
+    (return (add bytes signed wide single precise))
+)
+
+(func numberTypes D : a S b US c UI d UII e C f D
+    (var mask UI 0xFF_FF)
+    (var flags B 0b1010_1010)
+    (var million I 1_000_000)
+    (var widened II (add a b c))
+    (var unsigned UII (add d flags))
+    (var code I e)
+    (var letter C 'a')
+    (var newline C '\n')
+    (var small S (S million))
+    (var fromChar UI (UI letter))
+    (var toChar C (C 65))
+    (var money D (add f 1.5 2.5e-3D))
+    (var ratio FF (mul 1.5e3 -0x10 (add b 70_000)))
+    (if (eq newline toChar)
+        (return money))
+    (return (add money mask widened unsigned code small fromChar (D ratio)))
+)
+
+(func strings Str : a Str b Str c I
+    (var joined Str (cat a ` and ` b))
+    (var escaped Str `line\n\ttab \`tick\` \u00e9`)
+    (var interpolated Str $`{a} and {b} make {(add c 2)} {{braces}}`)
+    (var path Str @`C:\temp\new`)
+    (var block Str @`
+        first
+          indented
+        last
+        `)
+    (return (cat joined escaped interpolated path block))
+)
+
+(func conversions I : a Shape b FF
+    (var whole I (cast I b))
+    (var isCircle Bool (istype Circle a))
+    (var circle Circle (astype Circle a))
+    (var shape Shape (ife isCircle circle (Square)))
+    (var t Type (typeof Circle))
+    (var size I (sizeof II))
+    (var zero FF (default FF))
+    (if (eq t (typeof Square))
+        (return (add whole size)))
+    (if (istype Square shape)
+        (return (cast I zero)))
+    (return 0)
+)
+
+(func compoundAssignment I : a I b Bool
+    (var x I a)
+    (asadd x 2)
+    (assub x 1)
+    (asmul x 3)
+    (asdiv x 2)
+    (asmod x 5)
+    (asinc x)
+    (asdec x)
+    (asshl x 2)
+    (asshr x 1)
+    (asband x 7)
+    (asbor x 8)
+    (var y Bool b)
+    (asand y (eq x 4))
+    (asor y (eq x 5))
+    (if y
+        (return x))
+    (return 0)
+)
+
+(func nullables I : a Pet? b I?
+    (var found Bool false)
+    (var none Pet? nil)
+    (var name Str? [name owner? a?])
+    (var age I (coalesce [age a?] b 0))
+    (var wide II? (astype II? (cast Any age)))
+    (if (neq a nil)
+        (as found true)
+        (as none [owner a]))
+    (if (and found (neq b nil))
+        (return (add age (cast I b))))
+    (if (eq name nil)
+        (return (ife (eq wide nil) 0 1)))
+    (return (ife (eq none nil) age 0))
+)
+
+(class Pet
+    (f name Str `rex`)
+    (f owner Pet?)
+    (f age I? 3)
+)
+
+(class Shape)
+(class Circle : Shape)
+(class Square : Shape)
@@ -0,0 +1,168 @@
+namespace Operators {
+	public class _Globals {
+	}
+
+	public class _Funcs {
+#line 5 "operators.bf"
+		public static int arithmetic(a int, b int) {
+			int sum = (a + b + 1);
+			int difference = (a - b);
+			int product = (a * b);
+			int quotient = (a / b);
+			int remainder = (a % b);
+			int next = (a + 1);
+			int previous = (a - 1);
+			return (sum + difference + product + quotient + remainder + next + previous);
+		}
+
+		public static int bitwise(a int, b int) {
+			int both = (a & b);
+			int either = (a | b);
+			int one = (a ^ b);
+			int flipped = (~a);
+			int left = (a << 2);
+			int right = (a >> b);
+			return (both | either | one | flipped | left | right);
+		}
+
+		public static bool logic(a bool, b bool) {
+			bool both = (a && b);
+			bool either = (a || b);
+			bool one = (a ^ b);
+			bool neither = (!either);
+			return (both && one && neither);
+		}
+
+		public static bool comparison(a int, b long) {
+			bool equal = (a == b);
+			bool unequal = (a != b);
+			bool less = (a < b);
+			bool greater = (a > b);
+			bool lessOrEqual = (a <= b && b <= (long) 10);
+			bool greaterOrEqual = (a >= b);
+			return (equal || unequal || less || greater || lessOrEqual || greaterOrEqual);
+		}
+
+		public static double promotion(a byte, b sbyte, c int, d long, e float) {
+			byte bytes = ((byte) (a + (byte) 1));
+			int signed = ((short) (a - b));
+			long wide = (c * d);
+			float single = (e / c);
+			double precise = (d + (double) 0.5);
+			return (bytes + signed + wide + single + precise);
+		}
+
+		public static decimal numberTypes(a short, b ushort, c uint, d ulong, e char, f decimal) {
+			uint mask = (uint) 0xFFFF;
+			byte flags = (byte) 0xAA;
+			int million = 1000000;
+			long widened = (a + b + c);
+			ulong unsigned = (d + flags);
+			int code = e;
+			char letter = 'a';
+			char newline = '\n';
+			short small = ((short) million);
+			uint fromChar = ((uint) letter);
+			char toChar = ((char) 65);
+			decimal money = (f + 1.5m + 2.5e-3m);
+			double ratio = ((double) 1.5e3 * (double) -0x10 * (b + 70000));
+			if ((newline == toChar)) {
+				return money;
+			}
+#line 69 "operators.bf"
+			return (money + mask + widened + unsigned + code + small + fromChar + ((decimal) ratio));
+		}
+
+		public static string strings(a string, b string, c int) {
+			string joined = (a + " and " + b);
+			string escaped = "line\n\ttab `tick` é";
+			string interpolated = $"{a} and {b} make {(c + 2)} {{braces}}";
+			string path = "C:\\temp\\new";
+			string block = "first\n  indented\nlast";
+#line 82 "operators.bf"
+			return (joined + escaped + interpolated + path + block);
+		}
+
+		public static int conversions(a Operators.Shape, b double) {
+			int whole = ((int) b);
+			bool isCircle = (a is Operators.Circle);
+			Operators.Circle circle = (a as Operators.Circle);
+			Operators.Shape shape = (isCircle ? (Operators.Shape) circle : (Operators.Shape) new Operators.Square());
+			System.Type t = typeof(Operators.Circle);
+			int size = sizeof(long);
+			double zero = default(double);
+			if ((t == typeof(Operators.Square))) {
+				return (whole + size);
+			}
+#line 95 "operators.bf"
+			if ((shape is Operators.Square)) {
+				return ((int) zero);
+			}
+#line 97 "operators.bf"
+			return 0;
+		}
+
+		public static int compoundAssignment(a int, b bool) {
+			int x = a;
+			x += 2;
+			x -= 1;
+			x *= 3;
+			x /= 2;
+			x %= 5;
+			x++;
+			x--;
+			x <<= 2;
+			x >>= 1;
+			x &= 7;
+			x |= 8;
+			bool y = b;
+			y &= (x == 4);
+			y |= (x == 5);
+			if (y) {
+				return x;
+			}
+#line 118 "operators.bf"
+			return 0;
+		}
+
+		public static int nullables(a Operators.Pet, b int?) {
+			bool found = false;
+			Operators.Pet none = null;
+			string name = a?.owner?.name;
+			int age = (a?.age ?? b ?? 0);
+			long? wide = (((object) age) as long?);
+			if ((a != null)) {
+				found = true;
+				none = a.owner;
+			}
+#line 130 "operators.bf"
+			if ((found && (b != null))) {
+				return (age + ((int) b));
+			}
+#line 132 "operators.bf"
+			if ((name == null)) {
+				return ((wide == null) ? 0 : 1);
+			}
+#line 134 "operators.bf"
+			return ((none == null) ? age : 0);
+		}
+#line default
+	}
+
+	public class Pet {
+#line 138 "operators.bf"
+		public string name = "rex";
+		public Operators.Pet owner;
+		public int? age = 3;
+	}
+#line default
+
+	public class Shape {
+	}
+
+	public class Circle : Operators.Shape {
+	}
+
+	public class Square : Operators.Shape {
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// operators.bf compiles to operators.cs (regenerate operators.cs when the output changes on purpose)
+func TestOperators(t *testing.T) {
+	topDefs, err := parseFile("operators.bf", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, err := createNamespace(topDefs, "operators", map[NSNameFull]*Namespace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Options = buildOptions{OutDir: "."}
+	files, err := codeGen(topDefs, ns, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "operators.cs" {
+		t.Fatalf("expected only operators.cs, got %d files", len(files))
+	}
+	data, err := ioutil.ReadFile("operators.cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(files[0].Code, "\n")
+	want := strings.Split(string(data), "\n")
+	for i := 0; i < len(got) || i < len(want); i++ {
+		gotLine, wantLine := "(end of file)", "(end of file)"
+		if i < len(got) {
+			gotLine = got[i]
+		}
+		if i < len(want) {
+			wantLine = want[i]
+		}
+		if gotLine != wantLine {
+			t.Fatalf("operators.cs line %d is %q, expected %q", i+1, gotLine, wantLine)
+		}
+	}
+}
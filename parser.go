@@ -3,15 +3,29 @@ package main
 import (
 	"errors"
 	"strings"
+	"unicode/utf16"
 
 	"github.com/davecgh/go-spew/spew"
 )
 
 func parse(readerData []Atom, topDefs *TopDefs, isMain bool) error {
 	annotations := []AnnotationForm{}
+	ownerIdx := -1             // index in topDefs.Funcs of the last non-helper func of this file
+	var helperPath []ShortName // non-nil when a helper form precedes the next func
 
 	for _, atom := range readerData {
 		switch atom := atom.(type) {
+		case AtomChain:
+			// helper form: -help followed by zero or more helper names
+			if !parseFlag(atom, "help") || helperPath != nil {
+				return errors.New("Invalid top-level atom: " + spew.Sdump(atom))
+			}
+			helperPath = []ShortName{}
+		case Symbol:
+			if helperPath == nil {
+				return errors.New("Invalid top-level atom: " + spew.Sdump(atom))
+			}
+			helperPath = append(helperPath, ShortName(atom.Content))
 		case ParenList:
 			elems := atom.Atoms
 			if len(elems) == 0 {
@@ -32,6 +46,9 @@ func parse(readerData []Atom, topDefs *TopDefs, isMain bool) error {
 			if !ok {
 				return errors.New("Invalid top-level atom: " + spew.Sdump(atom))
 			}
+			if helperPath != nil && first.Content != "func" {
+				return msg(first.Line, first.Column, "Helper form must be followed by a function.")
+			}
 			switch first.Content {
 			case "class":
 				class, err := parseClass(atom, annotations)
@@ -52,11 +69,23 @@ func parse(readerData []Atom, topDefs *TopDefs, isMain bool) error {
 				if err != nil {
 					return err
 				}
+				annotations = []AnnotationForm{} // reset to empty slice
+				if helperPath != nil {
+					if ownerIdx == -1 {
+						return msg(funcDef.Line, funcDef.Column, "Helper function must follow the function it belongs to.")
+					}
+					err = attachHelper(&topDefs.Funcs[ownerIdx], helperPath, funcDef)
+					if err != nil {
+						return err
+					}
+					helperPath = nil
+					continue
+				}
 				if !isMain && funcDef.Name == "main" {
 					return msg(funcDef.Line, funcDef.Column, "A 'main' function can only be declared in the main file of the namespace.")
 				}
 				topDefs.Funcs = append(topDefs.Funcs, funcDef)
-				annotations = []AnnotationForm{} // reset to empty slice
+				ownerIdx = len(topDefs.Funcs) - 1
 			case "interface":
 				interfaceDef, err := parseInterface(atom, annotations)
 				if err != nil {
@@ -88,6 +117,28 @@ func parse(readerData []Atom, topDefs *TopDefs, isMain bool) error {
 			return errors.New("Invalid top-level atom: " + spew.Sdump(atom))
 		}
 	}
+	if helperPath != nil {
+		return errors.New("Helper form must be followed by a function.")
+	}
+	return nil
+}
+
+// attach helper to owner, or to the helper of owner designated by path
+// (a path name refers to the last helper of that name)
+func attachHelper(owner *FuncDef, path []ShortName, helper FuncDef) error {
+	for _, name := range path {
+		var next *FuncDef
+		for i := range owner.Helpers {
+			if owner.Helpers[i].Name == name {
+				next = &owner.Helpers[i]
+			}
+		}
+		if next == nil {
+			return msg(helper.Line, helper.Column, "Helper form names unknown helper '"+string(name)+"' of function '"+string(owner.Name)+"'.")
+		}
+		owner = next
+	}
+	owner.Helpers = append(owner.Helpers, helper)
 	return nil
 }
 
@@ -106,10 +157,13 @@ func parseAnnotation(parens ParenList) (AnnotationForm, error) {
 
 // parse (potentially) qualified name
 func parseVarExpression(atom Atom) (VarExpression, error) {
-	expr := VarExpression{}
+	expr := VarExpression{
+		Line:   atom.GetLine(),
+		Column: atom.GetColumn(),
+	}
 	switch atom := atom.(type) {
 	case Symbol:
-		if atom.Content == strings.Title(atom.Content) {
+		if isCapitalized(atom.Content) {
 			return VarExpression{}, errors.New("Invalid name (cannot begin with uppercase): " + spew.Sdump(atom))
 		}
 		expr.Name = ShortName(atom.Content)
@@ -119,7 +173,7 @@ func parseVarExpression(atom Atom) (VarExpression, error) {
 			return VarExpression{}, errors.New("Invalid name: " + spew.Sdump(atom))
 		}
 		if symbol, ok := atoms[0].(Symbol); ok {
-			if symbol.Content == strings.Title(symbol.Content) {
+			if isCapitalized(symbol.Content) {
 				return VarExpression{}, errors.New("Invalid name (cannot begin with uppercase): " + spew.Sdump(atom))
 			}
 			expr.Name = ShortName(symbol.Content)
@@ -152,6 +206,7 @@ func parseClass(parens ParenList, annotations []AnnotationForm) (ClassDef, error
 		Methods:      structDef.Methods,
 		Constructors: structDef.Constructors,
 		Properties:   structDef.Properties,
+		Indexers:     structDef.Indexers,
 		Annotations:  structDef.Annotations,
 	}, nil
 }
@@ -262,6 +317,13 @@ func parseStruct(parens ParenList, annotations []AnnotationForm, isClass bool) (
 				}
 				structDef.Properties = append(structDef.Properties, property)
 				annotations = []AnnotationForm{} // reset to empty slice
+			case "indexer":
+				indexer, err := parseIndexer(atom, annotations)
+				if err != nil {
+					return StructDef{}, err
+				}
+				structDef.Indexers = append(structDef.Indexers, indexer)
+				annotations = []AnnotationForm{} // reset to empty slice
 			case "constructor":
 				constructor, err := parseConstructor(atom, annotations)
 				if err != nil {
@@ -330,7 +392,7 @@ func parseTypeAtom(atom Atom) (TypeAtom, error) {
 	dataType := TypeAtom{}
 	switch atom := atom.(type) {
 	case Symbol:
-		if atom.Content != strings.Title(atom.Content) {
+		if !isCapitalized(atom.Content) {
 			return TypeAtom{}, errors.New("Type name must begin with capital letter")
 		}
 		dataType.Name = ShortName(atom.Content)
@@ -341,8 +403,24 @@ func parseTypeAtom(atom Atom) (TypeAtom, error) {
 		if len(atoms) < 1 {
 			return TypeAtom{}, errors.New("Invalid type spec: " + spew.Sdump(atom))
 		}
+		if sigil, ok := atoms[len(atoms)-1].(SigilAtom); ok && sigil.Content == "?" && len(atoms) > 1 {
+			// a nullable type, e.g. I? or A<Dog>?
+			var base Atom = AtomChain{atoms[:len(atoms)-1], atom.Line, atom.Column}
+			if len(atoms) == 2 {
+				base = atoms[0]
+			}
+			dataType, err := parseTypeAtom(base)
+			if err != nil {
+				return TypeAtom{}, err
+			}
+			if dataType.Nullable {
+				return TypeAtom{}, errors.New("Invalid type spec (nullable type cannot be made nullable): " + spew.Sdump(atom))
+			}
+			dataType.Nullable = true
+			return dataType, nil
+		}
 		if symbol, ok := atoms[0].(Symbol); ok {
-			if symbol.Content != strings.Title(symbol.Content) {
+			if !isCapitalized(symbol.Content) {
 				return TypeAtom{}, errors.New("Type name must begin with capital letter")
 			}
 			dataType.Name = ShortName(symbol.Content)
@@ -421,7 +499,7 @@ func parseImportDef(parens ParenList, annotations []AnnotationForm) (ImportDef,
 	if !ok {
 		return ImportDef{}, errors.New("Invalid import form. Expecting symbol. " + spew.Sdump(atoms))
 	}
-	if symbol.Content == strings.Title(symbol.Content) {
+	if isCapitalized(symbol.Content) {
 		return ImportDef{}, errors.New("Invalid import form: imported namespace cannot start with uppercase letter. " + spew.Sdump(atoms))
 	}
 	exclusions := []string{}
@@ -450,7 +528,7 @@ func parseImportDef(parens ParenList, annotations []AnnotationForm) (ImportDef,
 			idx++
 			if symbol, ok := atoms[idx].(Symbol); ok {
 				shortname = symbol.Content
-				if shortname == strings.Title(shortname) {
+				if isCapitalized(shortname) {
 					return ImportDef{}, msg(parens.Line, parens.Column, "Import shortname cannot start with uppercase letter.")
 				}
 			} else {
@@ -492,8 +570,8 @@ func parseImportDef(parens ParenList, annotations []AnnotationForm) (ImportDef,
 							return ImportDef{}, msg(parens.Line, parens.Column, "Alias form in import expecting symbol for alias.")
 						}
 						// alias starting cases should match
-						if (original == strings.Title(original)) !=
-							(substitute == strings.Title(substitute)) {
+						if (isCapitalized(original)) !=
+							(isCapitalized(substitute)) {
 							return ImportDef{}, msg(parens.Line, parens.Column, "Alias form in import expecting symbols with same starting letter case.")
 						}
 						aliases[original] = substitute
@@ -533,7 +611,7 @@ func parseNamespace(atoms []Atom, line int, column int) (string, error) {
 	}
 
 	if symbol, ok := atoms[1].(Symbol); ok {
-		if symbol.Content == strings.Title(symbol.Content) {
+		if isCapitalized(symbol.Content) {
 			return "", errors.New("Improperly formed namespace qualifier (namspace cannot begin with uppercase): line " + itoa(line) + " column " + itoa(column))
 		}
 		return symbol.Content, nil
@@ -554,70 +632,19 @@ func parseExpression(atom Atom) (Expression, error) {
 	var expr Expression
 	switch atom := atom.(type) {
 	case NumberAtom:
+		// the lexer has already parsed the literal, including any leading -
 		expr = ParsedNumberAtom{
-			IntegerPart: atom.Content,
-			Line:        atom.Line,
-			Column:      atom.Column,
+			Value:  atom.Value,
+			Line:   atom.Line,
+			Column: atom.Column,
 		}
 	case StringAtom:
-		expr = atom
-	case AtomChain:
-		// test if atom's a valid number, e.g. -35.98
-		elems := atom.Atoms
-		if len(elems) < 1 {
-			return nil, errors.New("Invalid expression: " + spew.Sdump(atom))
-		}
-		// optional leading -
-		idx := 0
-		integerPart := ""
-		if sigil, ok := elems[0].(SigilAtom); ok {
-			if sigil.Content == "-" {
-				integerPart += "-"
-				idx++
-				if len(elems) < 2 {
-					return nil, errors.New("Invalid expression (unexpected sigil): " + spew.Sdump(atom))
-				}
-			} else {
-				return nil, errors.New("Invalid expression (unexpected sigil): " + spew.Sdump(atom))
-			}
-		}
-		// expecting number
-		if num, ok := elems[idx].(NumberAtom); ok {
-			integerPart += num.Content
-			idx++
-		} else {
-			return nil, errors.New("Invalid expression: " + spew.Sdump(atom))
-		}
-		fractionalPart := ""
-		if idx < len(elems) {
-			// optional dot followed by number
-			if sigil, ok := elems[idx].(SigilAtom); ok {
-				if sigil.Content != "." {
-					return nil, errors.New("Invalid expression (unexpected sigil): " + spew.Sdump(atom))
-				}
-			} else {
-				return nil, errors.New("Invalid expression (expected .): " + spew.Sdump(elems[idx]))
-			}
-			idx++
-			if idx >= len(elems) {
-				return nil, errors.New("Invalid number literal: expecting fractional part after decimal point: " + spew.Sdump(elems[idx]))
-			}
-			if num, ok := elems[idx].(NumberAtom); ok {
-				fractionalPart = num.Content
-			} else {
-				return nil, errors.New("Invalid expression (expected number): " + spew.Sdump(elems[idx]))
-			}
-			idx++
+		if strings.HasPrefix(atom.Content, "$") {
+			return parseInterpolatedString(atom)
 		}
-		if idx < len(elems) {
-			return nil, errors.New("Invalid number literal (unexpected atoms): " + spew.Sdump(elems[idx]))
-		}
-		return ParsedNumberAtom{
-			IntegerPart:    integerPart,
-			FractionalPart: fractionalPart,
-			Line:           atom.Line,
-			Column:         atom.Column,
-		}, nil
+		expr = atom
+	case CharAtom:
+		expr = atom
 	case SquareList:
 		expr, err = parseIndexing(atom, atom.Line, atom.Column)
 		if err != nil {
@@ -681,6 +708,106 @@ func parseExpression(atom Atom) (Expression, error) {
 	return expr, nil
 }
 
+// splits $`...` into its texts and the expressions in its braces (the lexer has checked the braces match)
+func parseInterpolatedString(atom StringAtom) (Expression, error) {
+	src := []rune(atom.Content[2 : len(atom.Content)-1])
+	form := InterpolatedStringForm{Line: atom.Line, Column: atom.Column}
+	line, column := atom.Line, atom.Column+2 // the position of src[i]
+	text := []rune{}
+	for i := 0; i < len(src); {
+		r := src[i]
+		switch {
+		case r == '\\':
+			// an escape sequence, resolved with the rest of the text (\u and \U are followed only by hex digits)
+			text = append(text, r, src[i+1])
+			i += 2
+			column += 2
+		case (r == '{' || r == '}') && i+1 < len(src) && src[i+1] == r:
+			text = append(text, r)
+			i += 2
+			column += 2
+		case r == '{':
+			end := i + 1
+			for depth := 1; ; end++ {
+				if src[end] == '`' || ((src[end] == '$' || src[end] == '@') && src[end+1] == '`') {
+					n, _ := lexString(src, end)
+					end += n - 1
+				} else if src[end] == '{' {
+					depth++
+				} else if src[end] == '}' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			expr, err := parseEmbeddedExpression(string(src[i+1:end]), line, column+1)
+			if err != nil {
+				return nil, err
+			}
+			form.Texts = append(form.Texts, unescapeString(string(text)))
+			form.Exprs = append(form.Exprs, expr)
+			text = []rune{}
+			for _, c := range src[i : end+1] {
+				if c == '\n' {
+					line++
+					column = 1
+				} else {
+					column += utf16.RuneLen(c)
+				}
+			}
+			i = end + 1
+		default:
+			text = append(text, r)
+			if r == '\n' {
+				line++
+				column = 1
+			} else {
+				column += utf16.RuneLen(r)
+			}
+			i++
+		}
+	}
+	form.Texts = append(form.Texts, unescapeString(string(text)))
+	return form, nil
+}
+
+// parses the source of an expression inside an interpolated string, which starts at the line and column
+func parseEmbeddedExpression(source string, line int, column int) (Expression, error) {
+	tokens, err := lex(source + "\n")
+	if err != nil {
+		return nil, errors.New(err.Error() + " (in the interpolated string expression at line " + itoa(line) +
+			" and column " + itoa(column) + ")")
+	}
+	for i := range tokens {
+		if tokens[i].Line == 1 {
+			tokens[i].Column += column - 1
+		}
+		tokens[i].Line += line - 1
+	}
+	atoms, err := read(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(atoms) != 1 {
+		return nil, msg(line, column, "Each {} of an interpolated string must hold one expression.")
+	}
+	return parseExpression(atoms[0])
+}
+
+// nil, true and false are values, not names
+func isLiteralWord(word string) bool {
+	return word == nilWord || word == trueWord || word == falseWord
+}
+
+// an error if the symbol cannot name what it declares, e.g. a parameter or a local variable
+func checkDeclaredName(symbol Symbol, what string) error {
+	if isLiteralWord(symbol.Content) {
+		return msg(symbol.Line, symbol.Column, "Cannot use "+symbol.Content+" as a "+what+" name.")
+	}
+	return nil
+}
+
 func parseFlag(atom Atom, expected string) bool {
 	if chain, ok := atom.(AtomChain); ok {
 		if len(chain.Atoms) == 2 {
@@ -712,7 +839,7 @@ func parseMethod(parens ParenList, annotations []AnnotationForm) (MethodDef, err
 		methodDef.IsStatic = true
 	}
 	if symbol, ok := atoms[idx].(Symbol); ok {
-		if symbol.Content == strings.Title(symbol.Content) {
+		if isCapitalized(symbol.Content) {
 			return MethodDef{}, errors.New("Invalid method name (cannot begin with uppercase): " + spew.Sdump(symbol))
 		}
 		methodDef.Name = ShortName(symbol.Content)
@@ -736,6 +863,7 @@ func parseMethod(parens ParenList, annotations []AnnotationForm) (MethodDef, err
 		}
 		idx++
 		paramNames := []ShortName{}
+		paramPositions := []Position{}
 		paramTypes := []TypeAtom{}
 		for idx+1 < len(atoms) {
 			symbol, ok := atoms[idx].(Symbol)
@@ -746,11 +874,17 @@ func parseMethod(parens ParenList, annotations []AnnotationForm) (MethodDef, err
 			if err != nil {
 				return MethodDef{}, errors.New("Invalid parameter type: " + spew.Sdump(atoms[idx+1]))
 			}
+			err = checkDeclaredName(symbol, "parameter")
+			if err != nil {
+				return MethodDef{}, err
+			}
 			paramNames = append(paramNames, ShortName(symbol.Content))
+			paramPositions = append(paramPositions, Position{symbol.Line, symbol.Column})
 			paramTypes = append(paramTypes, dt)
 			idx += 2
 		}
 		methodDef.ParamNames = paramNames
+		methodDef.ParamPositions = paramPositions
 		methodDef.ParamTypes = paramTypes
 	}
 	stmts, err := parseBody(atoms[idx:])
@@ -809,7 +943,7 @@ func parseProperty(parens ParenList, annotations []AnnotationForm) (PropertyDef,
 		idx++
 	}
 	if symbol, ok := atoms[idx].(Symbol); ok {
-		if symbol.Content == strings.Title(symbol.Content) {
+		if isCapitalized(symbol.Content) {
 			return PropertyDef{}, errors.New("Invalid property name (cannot begin with uppercase): " + spew.Sdump(symbol))
 		}
 		propertyDef.Name = ShortName(symbol.Content)
@@ -844,6 +978,77 @@ func parseProperty(parens ParenList, annotations []AnnotationForm) (PropertyDef,
 	return propertyDef, nil
 }
 
+// (indexer Type : key KeyType (get ...) (set ...))
+func parseIndexer(parens ParenList, annotations []AnnotationForm) (IndexerDef, error) {
+	indexerDef := IndexerDef{
+		Line:        parens.Line,
+		Column:      parens.Column,
+		Annotations: annotations,
+	}
+	atoms := parens.Atoms
+	if len(atoms) < 6 {
+		return IndexerDef{}, errors.New("Incomplete indexer definition: " + spew.Sdump(parens))
+	}
+	idx := 1
+	var err error
+	indexerDef.Type, err = parseTypeAtom(atoms[idx])
+	if err != nil {
+		return IndexerDef{}, errors.New("Expecting type for indexer: " + spew.Sdump(atoms[idx]))
+	}
+	idx++
+	// params
+	if sigil, ok := atoms[idx].(SigilAtom); !ok || sigil.Content != ":" {
+		return IndexerDef{}, errors.New("Invalid indexer (expecting colon): " + spew.Sdump(parens))
+	}
+	idx++
+	paramNames := []ShortName{}
+	paramPositions := []Position{}
+	paramTypes := []TypeAtom{}
+	for idx+1 < len(atoms) {
+		symbol, ok := atoms[idx].(Symbol)
+		if !ok {
+			break
+		}
+		dt, err := parseTypeAtom(atoms[idx+1])
+		if err != nil {
+			return IndexerDef{}, errors.New("Invalid parameter type: " + spew.Sdump(atoms[idx+1]))
+		}
+		err = checkDeclaredName(symbol, "parameter")
+		if err != nil {
+			return IndexerDef{}, err
+		}
+		paramNames = append(paramNames, ShortName(symbol.Content))
+		paramPositions = append(paramPositions, Position{symbol.Line, symbol.Column})
+		paramTypes = append(paramTypes, dt)
+		idx += 2
+	}
+	if len(paramNames) == 0 {
+		return IndexerDef{}, msg(parens.Line, parens.Column, "Indexer must have at least one parameter.")
+	}
+	indexerDef.ParamNames = paramNames
+	indexerDef.ParamPositions = paramPositions
+	indexerDef.ParamTypes = paramTypes
+	if idx >= len(atoms) {
+		return IndexerDef{}, msg(parens.Line, parens.Column, "Indexer should have a getter or setter or both.")
+	}
+	// getter and setter are parsed the same as for a property
+	accessors := PropertyDef{}
+	for ; idx < len(atoms); idx++ {
+		if accessors.HasGetter && accessors.HasSetter {
+			return IndexerDef{}, errors.New("Indexer has unexpected atom after getter and setter: " + spew.Sdump(atoms[idx]))
+		}
+		err = parseGetterOrSetter(atoms[idx], &accessors)
+		if err != nil {
+			return IndexerDef{}, err
+		}
+	}
+	indexerDef.GetBody = accessors.GetBody
+	indexerDef.SetBody = accessors.SetBody
+	indexerDef.HasGetter = accessors.HasGetter
+	indexerDef.HasSetter = accessors.HasSetter
+	return indexerDef, nil
+}
+
 func parseConstructor(parens ParenList, annotations []AnnotationForm) (ConstructorDef, error) {
 	constructorDef := ConstructorDef{
 		Line:        parens.Line,
@@ -862,6 +1067,7 @@ func parseConstructor(parens ParenList, annotations []AnnotationForm) (Construct
 		}
 		idx++
 		paramNames := []ShortName{}
+		paramPositions := []Position{}
 		paramTypes := []TypeAtom{}
 		for idx+1 < len(atoms) {
 			symbol, ok := atoms[idx].(Symbol)
@@ -872,11 +1078,17 @@ func parseConstructor(parens ParenList, annotations []AnnotationForm) (Construct
 			if err != nil {
 				return ConstructorDef{}, errors.New("Invalid parameter type: " + spew.Sdump(atoms[idx+1]))
 			}
+			err = checkDeclaredName(symbol, "parameter")
+			if err != nil {
+				return ConstructorDef{}, err
+			}
 			paramNames = append(paramNames, ShortName(symbol.Content))
+			paramPositions = append(paramPositions, Position{symbol.Line, symbol.Column})
 			paramTypes = append(paramTypes, dt)
 			idx += 2
 		}
 		constructorDef.ParamNames = paramNames
+		constructorDef.ParamPositions = paramPositions
 		constructorDef.ParamTypes = paramTypes
 	}
 	stmts, err := parseBody(atoms[idx:])
@@ -900,7 +1112,7 @@ func parseFunc(parens ParenList, annotations []AnnotationForm) (FuncDef, error)
 		return FuncDef{}, errors.New("Invalid function definition: " + spew.Sdump(parens))
 	}
 	if symbol, ok := atoms[idx].(Symbol); ok {
-		if symbol.Content == strings.Title(symbol.Content) {
+		if isCapitalized(symbol.Content) {
 			return FuncDef{}, errors.New("Invalid func name (cannot begin with uppercase): " + spew.Sdump(parens))
 		}
 		funcDef.Name = ShortName(symbol.Content)
@@ -926,6 +1138,7 @@ func parseFunc(parens ParenList, annotations []AnnotationForm) (FuncDef, error)
 		}
 		idx++
 		paramNames := []ShortName{}
+		paramPositions := []Position{}
 		paramTypes := []TypeAtom{}
 		for idx+1 < len(atoms) {
 			symbol, ok := atoms[idx].(Symbol)
@@ -936,11 +1149,17 @@ func parseFunc(parens ParenList, annotations []AnnotationForm) (FuncDef, error)
 			if err != nil {
 				return FuncDef{}, errors.New("Invalid parameter type: " + spew.Sdump(atoms[idx+1]))
 			}
+			err = checkDeclaredName(symbol, "parameter")
+			if err != nil {
+				return FuncDef{}, err
+			}
 			paramNames = append(paramNames, ShortName(symbol.Content))
+			paramPositions = append(paramPositions, Position{symbol.Line, symbol.Column})
 			paramTypes = append(paramTypes, dt)
 			idx += 2
 		}
 		funcDef.ParamNames = paramNames
+		funcDef.ParamPositions = paramPositions
 		funcDef.ParamTypes = paramTypes
 	}
 	stmts, err := parseBody(atoms[idx:])
@@ -986,8 +1205,13 @@ func parseBody(atoms []Atom) ([]Statement, error) {
 				stmt, err = parseContinue(elems, symbol.Line, symbol.Column)
 			case "var":
 				stmt, err = parseVar(elems, symbol.Line, symbol.Column)
-			case "as":
+			case "as", "asadd", "assub", "asmul", "asdiv", "asmod", "asinc", "asdec",
+				"asshl", "asshr", "asband", "asbor", "asand", "asor":
 				stmt, err = parseAssignment(elems, symbol.Line, symbol.Column)
+			case "block":
+				stmt, err = parseBlock(elems, symbol.Line, symbol.Column)
+			case "ast":
+				stmt, err = parseTemp(elems, symbol.Line, symbol.Column)
 			default:
 				expr, err := parseExpression(atoms[i])
 				if err != nil {
@@ -1018,9 +1242,12 @@ func parseBody(atoms []Atom) ([]Statement, error) {
 }
 
 func parseIf(atoms []Atom) (IfForm, int, error) {
-	ifForm := IfForm{}
 	// parse if clause
 	ifAtoms := atoms[0].(ParenList).Atoms
+	ifForm := IfForm{
+		Line:   ifAtoms[0].GetLine(),
+		Column: ifAtoms[0].GetColumn(),
+	}
 	if len(ifAtoms) < 2 {
 		return IfForm{}, 0, errors.New("Invalid if form (expecting condition): " + spew.Sdump(atoms))
 	}
@@ -1036,7 +1263,7 @@ func parseIf(atoms []Atom) (IfForm, int, error) {
 	// parse elif clauses and else clause
 	n := 1
 Loop:
-	for _, atom := range atoms {
+	for _, atom := range atoms[1:] {
 		parens, ok := atom.(ParenList)
 		if !ok {
 			break Loop
@@ -1085,7 +1312,21 @@ func parseIndexing(square SquareList, line int, column int) (IndexingForm, error
 		return IndexingForm{}, msg(line, column, "Indexing expression cannot be empty square brackets.")
 	}
 	args := make([]Expression, len(atoms))
+	conditional := make([]bool, len(atoms))
 	for i, a := range atoms {
+		// a trailing ? on a value means it's accessed with ?. (null-conditional access)
+		if chain, ok := a.(AtomChain); ok && len(chain.Atoms) > 1 {
+			if sigil, ok := chain.Atoms[len(chain.Atoms)-1].(SigilAtom); ok && sigil.Content == "?" {
+				if i == 0 {
+					return IndexingForm{}, msg(line, column, "Indexing form cannot end with a null-conditional (?) value.")
+				}
+				conditional[i] = true
+				a = chain.Atoms[0]
+				if len(chain.Atoms) > 2 {
+					a = AtomChain{chain.Atoms[:len(chain.Atoms)-1], chain.Line, chain.Column}
+				}
+			}
+		}
 		expr, err := parseExpression(a)
 		if err != nil {
 			return IndexingForm{}, err
@@ -1099,16 +1340,24 @@ func parseIndexing(square SquareList, line int, column int) (IndexingForm, error
 			Name:      thisWord,
 			Namespace: "",
 		})
+		conditional = append(conditional, false)
 	}
 	return IndexingForm{
-		Line:   square.Line,
-		Column: square.Column,
-		Args:   args,
+		Line:        square.Line,
+		Column:      square.Column,
+		Args:        args,
+		Conditional: conditional,
 	}, nil
 }
 
+// (as target value), (asadd target value), etc., or (asinc target) and (asdec target)
 func parseAssignment(atoms []Atom, line int, column int) (AssignmentForm, error) {
-	if len(atoms) != 3 {
+	operator := ShortName(strings.TrimPrefix(atoms[0].(Symbol).Content, "as"))
+	nElems := 3
+	if operator == "inc" || operator == "dec" {
+		nElems = 2
+	}
+	if len(atoms) != nElems {
 		return AssignmentForm{}, errors.New("Assignment statement has wrong number of elements: " + spew.Sdump(atoms))
 	}
 	var target Target
@@ -1122,15 +1371,19 @@ func parseAssignment(atoms []Atom, line int, column int) (AssignmentForm, error)
 	if err != nil {
 		return AssignmentForm{}, err
 	}
-	value, err := parseExpression(atoms[2])
-	if err != nil {
-		return AssignmentForm{}, err
+	var value Expression
+	if nElems == 3 {
+		value, err = parseExpression(atoms[2])
+		if err != nil {
+			return AssignmentForm{}, err
+		}
 	}
 	return AssignmentForm{
-		Line:   line,
-		Column: column,
-		Target: target,
-		Value:  value,
+		Line:     line,
+		Column:   column,
+		Operator: operator,
+		Target:   target,
+		Value:    value,
 	}, nil
 }
 
@@ -1231,9 +1484,13 @@ func parseVar(atoms []Atom, line int, column int) (VarForm, error) {
 	if !ok {
 		return VarForm{}, errors.New("Var statement expecting symbol for name: " + spew.Sdump(atoms))
 	}
-	if symbol.Content == strings.Title(symbol.Content) {
+	if isCapitalized(symbol.Content) {
 		return VarForm{}, errors.New("Local variable name must start lowercase: " + spew.Sdump(atoms))
 	}
+	err := checkDeclaredName(symbol, "local variable")
+	if err != nil {
+		return VarForm{}, err
+	}
 	varForm := VarForm{
 		Line:   line,
 		Column: column,
@@ -1265,10 +1522,102 @@ func parseVar(atoms []Atom, line int, column int) (VarForm, error) {
 	return varForm, nil
 }
 
+// (ast name value) or (ast name Type value)
+func parseTemp(atoms []Atom, line int, column int) (TempForm, error) {
+	if len(atoms) != 3 && len(atoms) != 4 {
+		return TempForm{}, errors.New("Ast statement has wrong number of elements: " + spew.Sdump(atoms))
+	}
+	symbol, ok := atoms[1].(Symbol)
+	if !ok {
+		return TempForm{}, errors.New("Ast statement expecting symbol for name: " + spew.Sdump(atoms))
+	}
+	if isCapitalized(symbol.Content) {
+		return TempForm{}, errors.New("Temporary variable name must start lowercase: " + spew.Sdump(atoms))
+	}
+	err := checkDeclaredName(symbol, "temporary variable")
+	if err != nil {
+		return TempForm{}, err
+	}
+	tempForm := TempForm{
+		Line:   line,
+		Column: column,
+		Target: ShortName(symbol.Content),
+	}
+	if len(atoms) == 4 {
+		tempForm.Type, err = parseTypeAtom(atoms[2])
+		if err != nil {
+			return TempForm{}, err
+		}
+	}
+	tempForm.Value, err = parseExpression(atoms[len(atoms)-1])
+	if err != nil {
+		return TempForm{}, err
+	}
+	return tempForm, nil
+}
+
+// (block x y : a b : c d ...)
+// x and y are the exported variables (each optionally followed by a type),
+// a and b are captured read-only, c and d are captured read/write
+func parseBlock(atoms []Atom, line int, column int) (BlockForm, error) {
+	blockForm := BlockForm{
+		Line:   line,
+		Column: column,
+	}
+	idx := 1
+	for idx < len(atoms) {
+		symbol, ok := atoms[idx].(Symbol)
+		if !ok {
+			break
+		}
+		if isCapitalized(symbol.Content) {
+			return BlockForm{}, msg(symbol.Line, symbol.Column, "Block variable name must start lowercase.")
+		}
+		blockForm.Exports = append(blockForm.Exports, ShortName(symbol.Content))
+		idx++
+		dt := TypeAtom{}
+		if idx < len(atoms) {
+			var err error
+			dt, err = parseTypeAtom(atoms[idx])
+			if err == nil {
+				idx++
+			}
+		}
+		blockForm.ExportTypes = append(blockForm.ExportTypes, dt)
+	}
+	for nColons := 0; idx < len(atoms); idx++ {
+		if sigil, ok := atoms[idx].(SigilAtom); ok {
+			if sigil.Content != ":" || nColons == 2 {
+				return BlockForm{}, msg(sigil.Line, sigil.Column, "Invalid sigil in block form.")
+			}
+			nColons++
+			continue
+		}
+		symbol, ok := atoms[idx].(Symbol)
+		if !ok {
+			break
+		}
+		if nColons == 1 {
+			blockForm.ReadCaptures = append(blockForm.ReadCaptures, ShortName(symbol.Content))
+		} else {
+			blockForm.WriteCaptures = append(blockForm.WriteCaptures, ShortName(symbol.Content))
+		}
+	}
+	var err error
+	blockForm.Body, err = parseBody(atoms[idx:])
+	if err != nil {
+		return BlockForm{}, err
+	}
+	return blockForm, nil
+}
+
 func parseSwitch(atoms []Atom) (SwitchForm, int, error) {
-	switchForm := SwitchForm{}
 	// parse if clause
 	switchAtoms := atoms[0].(ParenList).Atoms
+	switchForm := SwitchForm{
+		Line:   switchAtoms[0].GetLine(),
+		Column: switchAtoms[0].GetColumn(),
+	}
 	if len(switchAtoms) < 2 {
 		return SwitchForm{}, 0, errors.New("Invalid switch form (expecting value): " + spew.Sdump(atoms))
 	}
@@ -1280,7 +1629,7 @@ func parseSwitch(atoms []Atom) (SwitchForm, int, error) {
 	// parse case clauses and default clause
 	n := 1
 Loop:
-	for _, atom := range atoms {
+	for _, atom := range atoms[1:] {
 		parens, ok := atom.(ParenList)
 		if !ok {
 			break Loop
@@ -1324,9 +1673,12 @@ Loop:
 }
 
 func parseTry(atoms []Atom) (TryForm, int, error) {
-	tryForm := TryForm{}
 	// parse if clause
 	ifAtoms := atoms[0].(ParenList).Atoms
+	tryForm := TryForm{
+		Line:   ifAtoms[0].GetLine(),
+		Column: ifAtoms[0].GetColumn(),
+	}
 	if len(ifAtoms) < 2 {
 		return TryForm{}, 0, errors.New("Invalid try form (expecting body): " + spew.Sdump(atoms))
 	}
@@ -1338,7 +1690,7 @@ func parseTry(atoms []Atom) (TryForm, int, error) {
 	// parse catch clauses and finally clause
 	n := 1
 Loop:
-	for _, atom := range atoms {
+	for _, atom := range atoms[1:] {
 		parens, ok := atom.(ParenList)
 		if !ok {
 			break Loop
@@ -1507,7 +1859,7 @@ func parseInterfaceProperty(parens ParenList) (p PropertyDef, err error) {
 		return
 	}
 	if symbol, ok := atoms[idx].(Symbol); ok {
-		if symbol.Content == strings.Title(symbol.Content) {
+		if isCapitalized(symbol.Content) {
 			err = msg(parens.Line, parens.Column, "Interface name must start with lowercase letter.")
 			return
 		}
@@ -1532,7 +1884,7 @@ func parseMethodSignature(parens ParenList) (paramTypes []TypeAtom, returnType T
 		return nil, TypeAtom{}, "", errors.New("Invalid method signature: " + spew.Sdump(parens))
 	}
 	if symbol, ok := atoms[1].(Symbol); ok {
-		if symbol.Content == strings.Title(symbol.Content) {
+		if isCapitalized(symbol.Content) {
 			err = errors.New("Invalid method name (cannot begin with uppercase): " + spew.Sdump(symbol))
 			return
 		}
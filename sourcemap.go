@@ -0,0 +1,207 @@
+package main
+
+// source maps: for each generated C# file, a <file>.map of which lines of the source produced which lines of the C#,
+// read from the #line directives in the C#, and the translation by bflat map-error of positions in the generated C#
+// (in the errors of the C# compiler and in stack traces) to positions in the source
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const sourceMapExt = ".map"
+
+type sourceMap struct {
+	Output   string // the generated C# file
+	Segments []sourceSegment
+}
+
+// a run of C# lines produced by consecutive lines of a source file
+type sourceSegment struct {
+	Line       int    // the first line of the run in the C#
+	Source     string // "" if the run has no source, e.g. the namespace and class wrapping the funcs
+	SourceLine int
+}
+
+// returns the file name and line of a #line directive ("" and 0 for #line default)
+func parseLineDirective(text string) (file string, line int, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "#line ") {
+		return "", 0, false
+	}
+	text = strings.TrimSpace(text[len("#line "):])
+	if text == "default" {
+		return "", 0, true
+	}
+	space := strings.Index(text, " ")
+	if space == -1 {
+		return "", 0, false
+	}
+	line, err := strconv.Atoi(text[:space])
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.Trim(text[space+1:], "\""), line, true
+}
+
+// removes each #line directive which attributes the next line as it would be anyway, i.e. where the
+// statement follows on the next line of the source, and each directive followed directly by another
+func pruneLineDirectives(code string) string {
+	lines := strings.SplitAfter(code, "\n")
+	kept := make([]string, 0, len(lines))
+	file := ""    // "" outside any directive's run
+	nextLine := 0 // of the source attributed to the next line of C#
+	for i, text := range lines {
+		directiveFile, directiveLine, ok := parseLineDirective(text)
+		if !ok {
+			kept = append(kept, text)
+			nextLine++
+			continue
+		}
+		if i+1 < len(lines) {
+			if _, _, next := parseLineDirective(lines[i+1]); next {
+				continue
+			}
+		}
+		if directiveFile == file && (file == "" || directiveLine == nextLine) {
+			continue
+		}
+		kept = append(kept, text)
+		file, nextLine = directiveFile, directiveLine
+	}
+	return strings.Join(kept, "")
+}
+
+func buildSourceMap(output string, code string) sourceMap {
+	m := sourceMap{Output: output, Segments: []sourceSegment{}}
+	for i, text := range strings.Split(code, "\n") {
+		file, line, ok := parseLineDirective(text)
+		if ok {
+			m.Segments = append(m.Segments, sourceSegment{i + 2, file, line})
+		}
+	}
+	return m
+}
+
+// the source file and line which produced the line of C#
+func (m *sourceMap) lookup(line int) (string, int, bool) {
+	for i := len(m.Segments) - 1; i >= 0; i-- {
+		segment := m.Segments[i]
+		if segment.Line <= line {
+			if segment.Source == "" {
+				return "", 0, false
+			}
+			return segment.Source, segment.SourceLine + line - segment.Line, true
+		}
+	}
+	return "", 0, false
+}
+
+// adds a source map for each generated C# file
+func withSourceMaps(files []outputFile) ([]outputFile, error) {
+	var all []outputFile
+	for _, file := range files {
+		data, err := json.MarshalIndent(buildSourceMap(file.Name, file.Code), "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, file, outputFile{file.Name + sourceMapExt, string(data)})
+	}
+	return all, nil
+}
+
+// a position in a C# file: as the C# compiler reports it, e.g. Foo.cs(12,5),
+// or in a stack trace, e.g. Foo.cs:12 (Mono and Unity) or Foo.cs:line 12 (.NET)
+var csPositionRegexp = regexp.MustCompile(`((?:[A-Za-z]:)?[^\s():"']*\.cs)(?:\((\d+),\d+\)|:(line )?(\d+))`)
+
+// copies the text, replacing each position in a generated C# file with the position in the source which produced it
+// (the source map of a C# file is looked for beside it, then in dir); positions without a source are left as they are
+func mapErrors(in io.Reader, out io.Writer, dir string) error {
+	maps := map[string]*sourceMap{}
+	findMap := func(csFile string) *sourceMap {
+		if m, ok := maps[csFile]; ok {
+			return m
+		}
+		var m *sourceMap
+		base := csFile[strings.LastIndexAny(csFile, `/\`)+1:]
+		for _, path := range []string{csFile + sourceMapExt, filepath.Join(dir, base+sourceMapExt)} {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			m = &sourceMap{}
+			if json.Unmarshal(data, m) != nil {
+				m = nil
+				continue
+			}
+			break
+		}
+		maps[csFile] = m
+		return m
+	}
+	replace := func(match string) string {
+		groups := csPositionRegexp.FindStringSubmatch(match)
+		csFile := groups[1]
+		lineText := groups[2]
+		if lineText == "" {
+			lineText = groups[4]
+		}
+		line, err := strconv.Atoi(lineText)
+		m := findMap(csFile)
+		if err != nil || m == nil {
+			return match
+		}
+		source, sourceLine, ok := m.lookup(line)
+		if !ok {
+			return match
+		}
+		if groups[2] != "" {
+			// the column in the C# is not a column in the source, so the position is given as the start of the line
+			// (still as line and column, which tools reading the errors of the C# compiler expect)
+			return source + "(" + strconv.Itoa(sourceLine) + ",1)"
+		}
+		return source + ":" + groups[3] + strconv.Itoa(sourceLine)
+	}
+	reader := bufio.NewReader(in)
+	for {
+		text, err := reader.ReadString('\n')
+		if text != "" {
+			_, writeErr := io.WriteString(out, csPositionRegexp.ReplaceAllStringFunc(text, replace))
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// maps the errors in each of the files in turn, or in the standard input if none are given
+func mapErrorFiles(files []string, dir string) error {
+	if len(files) == 0 {
+		return mapErrors(os.Stdin, os.Stdout, dir)
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = mapErrors(f, os.Stdout, dir)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPruneLineDirectives(t *testing.T) {
+	tests := []struct {
+		name string
+		code []string // lines
+		want []string
+	}{
+		{
+			name: "statements on consecutive lines",
+			code: []string{`#line 3 "a.bf"`, "x = 1;", `#line 4 "a.bf"`, "y = 2;", `#line 5 "a.bf"`, "z = 3;"},
+			want: []string{`#line 3 "a.bf"`, "x = 1;", "y = 2;", "z = 3;"},
+		},
+		{
+			name: "gap in the source",
+			code: []string{`#line 3 "a.bf"`, "x = 1;", `#line 7 "a.bf"`, "y = 2;"},
+			want: []string{`#line 3 "a.bf"`, "x = 1;", `#line 7 "a.bf"`, "y = 2;"},
+		},
+		{
+			name: "directive followed by another",
+			code: []string{`#line 3 "a.bf"`, `#line 9 "a.bf"`, "x = 1;"},
+			want: []string{`#line 9 "a.bf"`, "x = 1;"},
+		},
+		{
+			name: "another file",
+			code: []string{`#line 3 "a.bf"`, "x = 1;", `#line 4 "b.bf"`, "y = 2;"},
+			want: []string{`#line 3 "a.bf"`, "x = 1;", `#line 4 "b.bf"`, "y = 2;"},
+		},
+		{
+			name: "repeated default",
+			code: []string{"{", "#line default", "}", `#line 2 "a.bf"`, "x = 1;", "#line default", "}", "#line default", "}"},
+			want: []string{"{", "}", `#line 2 "a.bf"`, "x = 1;", "#line default", "}", "}"},
+		},
+	}
+	for _, test := range tests {
+		got := pruneLineDirectives(strings.Join(test.code, "\n") + "\n")
+		want := strings.Join(test.want, "\n") + "\n"
+		if got != want {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	code := strings.Join([]string{
+		"namespace Example {", // 1
+		`#line 3 "a.bf"`,      // 2
+		"x = 1;",              // 3: a.bf 3
+		"y = 2;",              // 4: a.bf 4
+		`#line 10 "b.bf"`,     // 5
+		"z = 3;",              // 6: b.bf 10
+		"#line default",       // 7
+		"}",                   // 8
+	}, "\n")
+	m := buildSourceMap("example.cs", code)
+	tests := []struct {
+		line   int
+		source string
+		want   int
+		ok     bool
+	}{
+		{1, "", 0, false},
+		{3, "a.bf", 3, true},
+		{4, "a.bf", 4, true},
+		{6, "b.bf", 10, true},
+		{8, "", 0, false},
+	}
+	for _, test := range tests {
+		source, line, ok := m.lookup(test.line)
+		if source != test.source || line != test.want || ok != test.ok {
+			t.Errorf("line %d: got %q %d %v, expected %q %d %v", test.line, source, line, ok, test.source, test.want, test.ok)
+		}
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	m := sourceMap{Output: "example.cs", Segments: []sourceSegment{{1, "", 0}, {3, "src/example.bf", 7}}}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeTree(t, map[string]string{"example.cs" + sourceMapExt: string(data)})
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"compiler error", "example.cs(5,9): error CS0029: Cannot convert", "src/example.bf(9,1): error CS0029: Cannot convert"},
+		{"mono stack trace", "  at Example._Funcs.f () [0x00001] in example.cs:4", "  at Example._Funcs.f () [0x00001] in src/example.bf:8"},
+		{".NET stack trace", "   at Example._Funcs.f() in example.cs:line 3", "   at Example._Funcs.f() in src/example.bf:line 7"},
+		{"line without a source", "example.cs(2,1): warning CS0168", "example.cs(2,1): warning CS0168"},
+		{"file without a map", "other.cs(5,9): error CS0029", "other.cs(5,9): error CS0029"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := mapErrors(strings.NewReader(test.in+"\n"), &out, dir)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if out.String() != test.want+"\n" {
+			t.Errorf("%s: got %q, expected %q", test.name, out.String(), test.want+"\n")
+		}
+	}
+}
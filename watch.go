@@ -0,0 +1,86 @@
+package main
+
+// bflat watch: polls the source directories and, once edits have stopped for a moment, builds again
+// (the build cache limits the work to the changed namespaces and the namespaces whose imports' APIs changed)
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const watchInterval = 250 * time.Millisecond // between polls of the source directories
+
+// how long the source files must stay unchanged before a build starts (so a burst of saves builds once)
+const watchQuiet = 300 * time.Millisecond
+
+// identifies a version of a source file
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// builds once, and again after each change to the source files; errors are reported and watching continues
+func watch(roots []NSNameFull, directories []string, stubDirectories []string, options buildOptions) {
+	watched := append(append([]string{}, directories...), stubDirectories...)
+	fmt.Println("Watching " + strings.Join(watched, ", ") + " (Ctrl+C to stop)")
+	var built map[string]fileStamp
+	for {
+		stamps := sourceStamps(watched)
+		if built == nil || !reflect.DeepEqual(stamps, built) {
+			if built != nil {
+				// debounce: wait until the files stop changing
+				for {
+					time.Sleep(watchQuiet)
+					next := sourceStamps(watched)
+					if reflect.DeepEqual(next, stamps) {
+						break
+					}
+					stamps = next
+				}
+			}
+			built = stamps
+			fmt.Println("[" + time.Now().Format("15:04:05") + "] Building...")
+			start := time.Now()
+			err := build(roots, directories, stubDirectories, options)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Println("Build failed. Waiting for changes...")
+			} else {
+				fmt.Println("Built in " + time.Since(start).String() + ". Waiting for changes...")
+			}
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+// the stamps of the source files in the directories and, recursively,
+// in their subdirectories whose names begin with bf. (the directories searched by buildNamespaceFileLookup)
+func sourceStamps(directories []string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	var visit func(dir string)
+	visit = func(dir string) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return // reported by the build
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			if file.IsDir() {
+				if strings.HasPrefix(file.Name(), directoryPrefix) {
+					visit(path)
+				}
+			} else if strings.HasSuffix(file.Name(), fileSuffix) {
+				stamps[path] = fileStamp{file.ModTime(), file.Size()}
+			}
+		}
+	}
+	for _, dir := range directories {
+		visit(dir)
+	}
+	return stamps
+}
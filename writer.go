@@ -0,0 +1,86 @@
+package main
+
+import "strings"
+
+// writes generated C# a line at a time, indented by the level of the braces enclosing it:
+// every brace opens at the end of a line and closes at the start of one, and a blank line is written
+// only where requested between members (and never directly after an opening brace or before a closing one)
+type codeWriter struct {
+	buf       strings.Builder
+	level     int
+	blank     bool // a blank line was requested before the next line
+	afterOpen bool // nothing written since the last opening brace
+}
+
+// a writer for code to be included later at the current level plus one, e.g. a block whose
+// body must be compiled before the declarations written ahead of it
+func (w *codeWriter) child() *codeWriter {
+	return &codeWriter{level: w.level + 1, afterOpen: true}
+}
+
+func (w *codeWriter) flushBlank() {
+	if w.blank && !w.afterOpen && w.buf.Len() > 0 {
+		w.buf.WriteString("\n")
+	}
+	w.blank = false
+	w.afterOpen = false
+}
+
+func (w *codeWriter) line(code string) {
+	w.flushBlank()
+	w.buf.WriteString(strings.Repeat("\t", w.level) + code + "\n")
+}
+
+// a preprocessor line, e.g. #line, which is not indented ("" writes nothing)
+func (w *codeWriter) directive(code string) {
+	if code == "" {
+		return
+	}
+	w.flushBlank()
+	w.buf.WriteString(code + "\n")
+}
+
+// a blank line before the next line, e.g. between methods (consecutive requests write one blank line)
+func (w *codeWriter) blankLine() {
+	w.blank = true
+}
+
+// writes the header and an opening brace (a bare brace if the header is ""), and indents the lines which follow
+func (w *codeWriter) open(header string) {
+	if header == "" {
+		w.line("{")
+	} else {
+		w.line(header + " {")
+	}
+	w.level++
+	w.afterOpen = true
+}
+
+func (w *codeWriter) close() {
+	w.blank = false
+	w.level--
+	w.buf.WriteString(strings.Repeat("\t", w.level) + "}\n")
+	w.afterOpen = false
+}
+
+// closes a brace and opens another after the header on the same line, e.g. } else {
+func (w *codeWriter) reopen(header string) {
+	w.blank = false
+	w.level--
+	w.buf.WriteString(strings.Repeat("\t", w.level) + "} " + header + " {\n")
+	w.level++
+	w.afterOpen = true
+}
+
+// writes the code of a child writer
+func (w *codeWriter) include(child *codeWriter) {
+	if child.buf.Len() == 0 {
+		return
+	}
+	w.flushBlank()
+	w.buf.WriteString(child.buf.String())
+}
+
+func (w *codeWriter) String() string {
+	return w.buf.String()
+}